@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+const (
+	ankiBasic = "basic"
+	ankiCloze = "cloze"
+)
+
+type ankiNote struct {
+	GUID     string
+	NoteType string
+	Front    string
+	Back     string
+	Tags     []string
+}
+
+// Tags can't contain spaces, and "::" nests them in Anki's browser
+func getAnkiTag(parts ...string) string {
+	for i, p := range parts {
+		p = strings.Join(strings.Fields(p), "_")
+		parts[i] = strings.ReplaceAll(p, "::", ":")
+	}
+
+	return strings.Join(parts, "::")
+}
+
+func getAnkiTags(doc *fileResult, annot *pdfutils.Annotation) []string {
+	title := getDocumentTitle(doc)
+	tags := []string{getAnkiTag(title)}
+
+	if len(annot.Section) > 0 {
+		tags = append(tags, getAnkiTag(append([]string{title}, annot.Section...)...))
+	}
+
+	if annot.ColorCategory != "" {
+		tags = append(tags, getAnkiTag("color", annot.ColorCategory))
+	}
+
+	return tags
+}
+
+// Fields are HTML, which also keeps tabs and newlines out of the TSV
+func ankiHTML(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+
+	for i, line := range lines {
+		lines[i] = html.EscapeString(strings.Join(strings.Fields(line), " "))
+	}
+
+	return strings.Join(lines, "<br>")
+}
+
+func isWordRune(r rune) bool {
+	return r == '-' || r == '\'' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Reports whether the runes on either side of a byte offset are both part
+// of a word
+func isInWord(s string, i int) bool {
+	before, _ := utf8.DecodeLastRuneInString(s[:i])
+	after, _ := utf8.DecodeRuneInString(s[i:])
+
+	return isWordRune(before) && isWordRune(after)
+}
+
+// Finds the sentence around the highlighted text in the page's text. The
+// highlight is widened to whole words, since highlights often stop short of
+// the last letter. Returns the text before the highlight, the highlight, and
+// the text after it within that sentence, or false if the highlight can't be
+// found, eg. when the page's text is hyphenated differently.
+func getSentenceContext(pageText string, text string) (string, string, string, bool) {
+	pageText = strings.Join(strings.Fields(pageText), " ")
+	text = strings.Join(strings.Fields(text), " ")
+
+	i := strings.Index(pageText, text)
+	if text == "" || i == -1 {
+		return "", "", "", false
+	}
+
+	end := i + len(text)
+	for i > 0 && isInWord(pageText, i) {
+		_, size := utf8.DecodeLastRuneInString(pageText[:i])
+		i -= size
+	}
+
+	for end < len(pageText) && isInWord(pageText, end) {
+		_, size := utf8.DecodeRuneInString(pageText[end:])
+		end += size
+	}
+
+	start := 0
+	for _, sep := range []string{". ", "? ", "! "} {
+		if j := strings.LastIndex(pageText[:i], sep); j != -1 && j+len(sep) > start {
+			start = j + len(sep)
+		}
+	}
+
+	sentenceEnd := len(pageText)
+	if strings.ContainsAny(pageText[end-1:end], ".?!") {
+		sentenceEnd = end
+	} else if j := strings.IndexAny(pageText[end:], ".?!"); j != -1 {
+		sentenceEnd = end + j + 1
+	}
+
+	return pageText[start:i], pageText[i:end], pageText[end:sentenceEnd], true
+}
+
+func getAnkiSource(doc *fileResult, annot *pdfutils.Annotation) string {
+	return fmt.Sprintf(`<div class="source">%s, p. %s</div>`, html.EscapeString(getDocumentTitle(doc)), html.EscapeString(annot.PageLabel))
+}
+
+// Images are copied under a name prefixed with the document's fingerprint,
+// since the images of different documents share the same base name
+func copyAnkiImage(fingerprint string, annot *pdfutils.Annotation) (string, error) {
+	name := filepath.Base(annot.ImagePath)
+	if len(fingerprint) >= 8 {
+		name = fingerprint[:8] + "-" + name
+	}
+
+	if err := copyFile(annot.ImagePath, filepath.Join(args.AnkiMedia, name)); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// Highlights with a comment become basic cards with the comment on the front
+// and the highlighted sentence on the back. With --anki-cards=cloze, every
+// highlight becomes a cloze deletion within its sentence instead. Image
+// annotations with a comment become basic cards when --anki-media is set.
+func getAnkiNotes(doc *fileResult) ([]*ankiNote, error) {
+	notes := []*ankiNote{}
+
+	// GUIDs are derived from the document's fingerprint rather than its
+	// checksum, which changes whenever the PDF is edited
+	fingerprint := ""
+	if doc.meta != nil {
+		fingerprint = doc.meta.Fingerprint
+	}
+
+	for _, annot := range doc.Annotations {
+		comment := ankiHTML(annot.Comment)
+		note := &ankiNote{
+			GUID:     fingerprint + "-" + annot.ID,
+			NoteType: "Basic",
+			Tags:     getAnkiTags(doc, annot),
+		}
+
+		switch {
+		case annot.Type == pdfutils.Image:
+			if comment == "" || annot.ImagePath == "" || args.AnkiMedia == "" {
+				continue
+			}
+
+			name, err := copyAnkiImage(fingerprint, annot)
+			if err != nil {
+				return nil, err
+			}
+
+			note.Front = comment
+			note.Back = fmt.Sprintf(`<img src="%s">`, html.EscapeString(name))
+		case annot.AnnotatedText != "":
+			if comment == "" && args.AnkiCards == ankiBasic {
+				continue
+			}
+
+			before, text, after, ok := getSentenceContext(getDocumentPageText(doc, annot.Page), annot.AnnotatedText)
+			if !ok {
+				text = annot.AnnotatedText
+			}
+
+			// Headings have no full stop, so they run into the first sentence
+			// of their section
+			for _, heading := range annot.Section {
+				before = strings.TrimPrefix(before, heading+" ")
+			}
+
+			text = ankiHTML(text)
+			before = html.EscapeString(before)
+			after = html.EscapeString(after)
+
+			if args.AnkiCards == ankiCloze {
+				note.NoteType = "Cloze"
+				note.Front = before + "{{c1::" + strings.ReplaceAll(text, "}}", "} }") + "}}" + after
+				note.Back = comment
+			} else {
+				note.Front = comment
+				note.Back = before + "<b>" + text + "</b>" + after
+			}
+		default:
+			continue
+		}
+
+		if note.Back != "" {
+			note.Back += "<br>"
+		}
+
+		note.Back += getAnkiSource(doc, annot)
+		notes = append(notes, note)
+	}
+
+	return notes, nil
+}
+
+// Writes the notes as a tab separated file with the headers Anki 2.1.55 and
+// later use to pick the note type and tags of each row. The GUID column lets
+// the file be imported again to update existing notes.
+func writeAnki(w *bytes.Buffer, docs []*fileResult) error {
+	if args.AnkiMedia != "" {
+		if err := os.MkdirAll(args.AnkiMedia, 0755); err != nil {
+			return err
+		}
+	}
+
+	w.WriteString("#separator:tab\n#html:true\n#guid column:1\n#notetype column:2\n#tags column:5\n")
+
+	for _, doc := range docs {
+		notes, err := getAnkiNotes(doc)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range notes {
+			w.WriteString(strings.Join([]string{n.GUID, n.NoteType, n.Front, n.Back, strings.Join(n.Tags, " ")}, "\t") + "\n")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestGetSentenceContext(t *testing.T) {
+	tests := []struct {
+		pageText string
+		text     string
+		before   string
+		exact    string
+		after    string
+		ok       bool
+	}{
+		{
+			pageText: "First sentence. The highlight is here, and it ends. Last one.",
+			text:     "highlight is",
+			before:   "The ",
+			exact:    "highlight is",
+			after:    " here, and it ends.",
+			ok:       true,
+		},
+		{
+			// Highlights are widened to whole words
+			pageText: "Memory consolidation happens during sleep.",
+			text:     "consolidat",
+			before:   "Memory ",
+			exact:    "consolidation",
+			after:    " happens during sleep.",
+			ok:       true,
+		},
+		{
+			pageText: "Die Gedächtniskonsolidierung geschieht im Schlaf.",
+			text:     "Gedäch",
+			before:   "Die ",
+			exact:    "Gedächtniskonsolidierung",
+			after:    " geschieht im Schlaf.",
+			ok:       true,
+		},
+		{
+			// Quotation marks and dashes aren't part of words
+			pageText: "Dubbed “big trauma”—such as life-threatening events.",
+			text:     "big",
+			before:   "Dubbed “",
+			exact:    "big",
+			after:    " trauma”—such as life-threatening events.",
+			ok:       true,
+		},
+		{
+			pageText: "Some text.",
+			text:     "missing",
+		},
+	}
+
+	for _, tt := range tests {
+		before, exact, after, ok := getSentenceContext(tt.pageText, tt.text)
+		if before != tt.before || exact != tt.exact || after != tt.after || ok != tt.ok {
+			t.Errorf("getSentenceContext(%q, %q) = %q, %q, %q, %v, want %q, %q, %q, %v",
+				tt.pageText, tt.text, before, exact, after, ok, tt.before, tt.exact, tt.after, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+type fileResult struct {
+	Path        string                 `json:"path"`
+	Checksum    string                 `json:"checksum,omitempty"`
+	Document    *documentMetadata      `json:"document,omitempty"`
+	Annotations []*pdfutils.Annotation `json:"annotations"`
+	Errors      []string               `json:"errors,omitempty"`
+
+	meta *documentMetadata
+}
+
+func isPDF(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".pdf")
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// Expands the input arguments into a list of PDF paths. The returned bool
+// reports whether the inputs should be treated as a batch, ie. more than one
+// input, a directory, or a glob pattern was supplied.
+func expandInputs(inputs []string, recursive bool) ([]string, bool, error) {
+	paths := []string{}
+	seen := map[string]bool{}
+	batch := len(inputs) > 1
+
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, input := range inputs {
+		if input == stdinPath {
+			add(input)
+			continue
+		}
+
+		info, err := os.Stat(input)
+
+		if err != nil && os.IsNotExist(err) && isGlob(input) {
+			matches, err := filepath.Glob(input)
+			if err != nil {
+				return nil, false, err
+			}
+
+			if len(matches) == 0 {
+				return nil, false, fmt.Errorf("Error: no files match %s", input)
+			}
+
+			batch = true
+			sort.Strings(matches)
+
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.IsDir() {
+					continue
+				}
+
+				add(match)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !info.IsDir() {
+			add(input)
+			continue
+		}
+
+		batch = true
+
+		err = filepath.WalkDir(input, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				if path != input && !recursive {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			if isPDF(path) {
+				add(path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return paths, batch, nil
+}
+
+// Each file in a batch writes its images to its own folder so that images
+// from files sharing a name never collide.
+func getBatchImageOutputPath(path string, checksum string) string {
+	if args.ImageOutputPath == "" {
+		return ""
+	}
+
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	if path == stdinPath {
+		stem = "stdin"
+	}
+
+	return filepath.Join(args.ImageOutputPath, fmt.Sprintf("%s-%s", stem, checksum[:8]))
+}
+
+func processFile(path string) *fileResult {
+	result := &fileResult{
+		Path:        path,
+		Annotations: []*pdfutils.Annotation{},
+	}
+
+	checksum, err := getFileChecksum(path)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	result.Checksum = checksum
+
+	annots, meta, err := extractAnnotations(path, checksum, getBatchImageOutputPath(path, checksum))
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	result.Annotations = annots
+	result.meta = meta
+
+	if args.Envelope {
+		result.Document = meta
+	}
+
+	return result
+}
+
+func processBatch(paths []string) []*fileResult {
+	results := make([]*fileResult, len(paths))
+	wg := sync.WaitGroup{}
+
+	for i, path := range paths {
+		i := i
+		path := path
+
+		wg.Add(1)
+		workers.acquire()
+
+		go func() {
+			defer func() {
+				workers.release()
+				wg.Done()
+			}()
+
+			results[i] = processFile(path)
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+type bibEntry struct {
+	Key string
+	IDs pdfutils.Identifiers
+}
+
+// A bibliography indexes citation keys by normalized DOI, arXiv ID, and ISBN
+type bibliography struct {
+	byDOI   map[string]string
+	byArXiv map[string]string
+	byISBN  map[string]string
+}
+
+var (
+	bibOnce sync.Once
+	bib     *bibliography
+	bibErr  error
+)
+
+func newBibliography(entries []*bibEntry) *bibliography {
+	b := &bibliography{
+		byDOI:   map[string]string{},
+		byArXiv: map[string]string{},
+		byISBN:  map[string]string{},
+	}
+
+	add := func(m map[string]string, id string, key string) {
+		if _, ok := m[id]; id != "" && !ok {
+			m[id] = key
+		}
+	}
+
+	for _, e := range entries {
+		add(b.byDOI, e.IDs.DOI, e.Key)
+		add(b.byArXiv, e.IDs.ArXiv, e.Key)
+		add(b.byISBN, e.IDs.ISBN, e.Key)
+	}
+
+	return b
+}
+
+func (b *bibliography) lookup(ids *pdfutils.Identifiers) string {
+	if ids == nil {
+		return ""
+	}
+
+	if key, ok := b.byDOI[ids.DOI]; ok && ids.DOI != "" {
+		return key
+	}
+
+	if key, ok := b.byArXiv[ids.ArXiv]; ok && ids.ArXiv != "" {
+		return key
+	}
+
+	if key, ok := b.byISBN[ids.ISBN]; ok && ids.ISBN != "" {
+		return key
+	}
+
+	return ""
+}
+
+// Reads the identifiers of an entry from the fields that commonly hold them.
+// URLs and notes are searched too, since arXiv preprints often only link to
+// their abstract page.
+func getBibEntryIdentifiers(fields map[string]string) pdfutils.Identifiers {
+	ids := pdfutils.Identifiers{}
+
+	if doi := fields["doi"]; doi != "" {
+		ids.DOI = pdfutils.NormalizeDOI(doi)
+	}
+
+	if isbn := fields["isbn"]; isbn != "" {
+		for _, i := range strings.FieldsFunc(isbn, func(r rune) bool { return r == ',' || r == ';' }) {
+			if ids.ISBN = pdfutils.NormalizeISBN(i); ids.ISBN != "" {
+				break
+			}
+		}
+	}
+
+	if strings.EqualFold(fields["archiveprefix"], "arxiv") || strings.EqualFold(fields["eprinttype"], "arxiv") {
+		ids.ArXiv = pdfutils.NormalizeArXiv(fields["eprint"])
+	}
+
+	if found := pdfutils.FindIdentifiers(fields["url"], fields["note"], fields["number"], fields["doi"]); found != nil {
+		if ids.ArXiv == "" {
+			ids.ArXiv = found.ArXiv
+		}
+
+		if ids.DOI == "" {
+			ids.DOI = found.DOI
+		}
+	}
+
+	return ids
+}
+
+func readBibTeXValue(src []rune, i int) (string, int) {
+	for i < len(src) && unicode.IsSpace(src[i]) {
+		i++
+	}
+
+	if i >= len(src) {
+		return "", i
+	}
+
+	value := strings.Builder{}
+
+	switch src[i] {
+	case '{':
+		depth := 0
+
+		for ; i < len(src); i++ {
+			switch src[i] {
+			case '{':
+				depth++
+				if depth == 1 {
+					continue
+				}
+			case '}':
+				depth--
+				if depth == 0 {
+					return value.String(), i + 1
+				}
+			}
+
+			value.WriteRune(src[i])
+		}
+	case '"':
+		for i++; i < len(src) && src[i] != '"'; i++ {
+			value.WriteRune(src[i])
+		}
+
+		return value.String(), i + 1
+	default:
+		for ; i < len(src) && src[i] != ',' && src[i] != '}' && src[i] != '\n'; i++ {
+			value.WriteRune(src[i])
+		}
+	}
+
+	return strings.TrimSpace(value.String()), i
+}
+
+// Parses the keys and fields of BibTeX and BibLaTeX entries. String macros
+// and concatenation are not expanded, since they aren't used for identifiers.
+func parseBibTeX(data string) []*bibEntry {
+	src := []rune(data)
+	entries := []*bibEntry{}
+
+	for i := 0; i < len(src); i++ {
+		if src[i] != '@' {
+			continue
+		}
+
+		open := i + 1
+		for open < len(src) && src[open] != '{' && src[open] != '(' {
+			open++
+		}
+
+		kind := strings.ToLower(strings.TrimSpace(string(src[i+1 : open])))
+		if open >= len(src) || kind == "comment" || kind == "string" || kind == "preamble" {
+			continue
+		}
+
+		comma := open + 1
+		for comma < len(src) && src[comma] != ',' {
+			comma++
+		}
+
+		key := strings.TrimSpace(string(src[open+1 : comma]))
+		fields := map[string]string{}
+		j := comma + 1
+
+		for j < len(src) {
+			eq := j
+			for eq < len(src) && src[eq] != '=' && src[eq] != '}' && src[eq] != ')' {
+				eq++
+			}
+
+			if eq >= len(src) || src[eq] != '=' {
+				j = eq
+				break
+			}
+
+			name := strings.ToLower(strings.TrimSpace(strings.Trim(string(src[j:eq]), ",")))
+			value, end := readBibTeXValue(src, eq+1)
+			fields[name] = value
+
+			j = end
+			for j < len(src) && (unicode.IsSpace(src[j]) || src[j] == ',') {
+				j++
+			}
+		}
+
+		if key != "" {
+			entries = append(entries, &bibEntry{key, getBibEntryIdentifiers(fields)})
+		}
+
+		i = j
+	}
+
+	return entries
+}
+
+func parseCSLJSON(data []byte) ([]*bibEntry, error) {
+	items := []map[string]interface{}{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+
+	entries := []*bibEntry{}
+
+	for _, item := range items {
+		key, _ := item["id"].(string)
+		if key == "" {
+			continue
+		}
+
+		fields := map[string]string{}
+
+		for name, value := range item {
+			if s, ok := value.(string); ok {
+				fields[strings.ToLower(name)] = s
+			}
+		}
+
+		entries = append(entries, &bibEntry{key, getBibEntryIdentifiers(fields)})
+	}
+
+	return entries, nil
+}
+
+func loadBibliography() (*bibliography, error) {
+	if args.Bib == "" {
+		return nil, nil
+	}
+
+	bibOnce.Do(func() {
+		data, err := os.ReadFile(args.Bib)
+		if err != nil {
+			bibErr = err
+			return
+		}
+
+		var entries []*bibEntry
+
+		if strings.ToLower(filepath.Ext(args.Bib)) == ".json" {
+			entries, err = parseCSLJSON(data)
+		} else {
+			entries = parseBibTeX(string(data))
+		}
+
+		if err != nil {
+			bibErr = fmt.Errorf("Error: invalid bibliography %s: %s", args.Bib, err)
+			return
+		}
+
+		bib = newBibliography(entries)
+	})
+
+	return bib, bibErr
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+func TestParseBibTeX(t *testing.T) {
+	data := `
+@comment{ignored, doi = {10.1000/comment}}
+@string{jnl = "Journal"}
+
+@article{smith2020,
+  title = {A {Nested} Title, with a comma},
+  doi = {https://doi.org/10.1000/ABC.123},
+  journal = jnl,
+}
+
+@book( jones2019 ,
+  ISBN = "0-306-40615-2",
+  year = 2019
+)
+
+@misc{lee2021,
+  eprint = {2101.00001v2},
+  archivePrefix = {arXiv}
+}
+
+@online{kim2022,
+  url = {https://arxiv.org/abs/2202.00002v1}
+}
+`
+
+	want := []*bibEntry{
+		{"smith2020", pdfutils.Identifiers{DOI: "10.1000/abc.123"}},
+		{"jones2019", pdfutils.Identifiers{ISBN: "9780306406157"}},
+		{"lee2021", pdfutils.Identifiers{ArXiv: "2101.00001"}},
+		{"kim2022", pdfutils.Identifiers{ArXiv: "2202.00002"}},
+	}
+
+	got := parseBibTeX(data)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBibTeX() =")
+		for _, e := range got {
+			t.Errorf("  %+v", e)
+		}
+	}
+}
+
+func TestReadBibTeXValue(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{` {A {Nested} value}, next`, "A {Nested} value"},
+		{` "quoted, value" }`, "quoted, value"},
+		{` 2019,`, "2019"},
+		{` macro }`, "macro"},
+	}
+
+	for _, tt := range tests {
+		if got, _ := readBibTeXValue([]rune(tt.src), 0); got != tt.want {
+			t.Errorf("readBibTeXValue(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+// The cache lives in a folder per set of extraction options. Within it, whole
+// files are keyed on their checksum, and pages on their fingerprint so that
+// unchanged pages of a modified PDF can be reused.
+type extractionCache struct {
+	dir string
+}
+
+// The options that affect the output. They key the cache, and are included
+// in the --envelope output.
+type extractionOptions struct {
+	IgnoreBefore  string   `json:"ignoreBefore,omitempty"`
+	IgnoreAfter   string   `json:"ignoreAfter,omitempty"`
+	Types         []string `json:"types"`
+	Colors        []string `json:"colors"`
+	Author        []string `json:"author"`
+	HasComment    bool     `json:"hasComment"`
+	Match         string   `json:"match"`
+	Pages         string   `json:"pages"`
+	PageLabels    bool     `json:"pageLabels"`
+	SkipImages    bool     `json:"skipImages"`
+	NoWrite       bool     `json:"noWrite"`
+	ImageBaseName string   `json:"imageBaseName"`
+	ImageFormat   string   `json:"imageFormat"`
+	ImageDPI      int      `json:"imageDPI"`
+	ImageQuality  int      `json:"imageQuality"`
+	ImageTypes    []string `json:"imageTypes"`
+	ImagePadding  float64  `json:"imagePadding"`
+	PageImages    bool     `json:"pageImages"`
+	ImageInline   bool     `json:"imageInline"`
+	ImageDataURI  bool     `json:"imageDataURI"`
+
+	NativeImages     bool   `json:"nativeImages"`
+	ClipNativeImages bool   `json:"clipNativeImages"`
+	AttemptOCR       bool   `json:"attemptOCR"`
+	OCRLang          string `json:"ocrLang"`
+	TesseractPath    string `json:"tesseractPath"`
+	TessDataDir      string `json:"tessDataDir"`
+
+	NoInferHeadings bool `json:"noInferHeadings"`
+}
+
+func formatOptionTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func getExtractionOptions(skipImages bool) extractionOptions {
+	return extractionOptions{
+		IgnoreBefore:  formatOptionTime(args.IgnoreBefore),
+		IgnoreAfter:   formatOptionTime(args.IgnoreAfter),
+		Types:         args.Types,
+		Colors:        args.Colors,
+		Author:        args.Author,
+		HasComment:    args.HasComment,
+		Match:         args.Match,
+		Pages:         args.Pages,
+		PageLabels:    args.PageLabels,
+		SkipImages:    skipImages,
+		NoWrite:       args.NoWrite,
+		ImageBaseName: args.ImageBaseName,
+		ImageFormat:   args.ImageFormat,
+		ImageDPI:      args.ImageDPI,
+		ImageQuality:  args.ImageQuality,
+		ImageTypes:    args.ImageTypes,
+		ImagePadding:  args.ImagePadding,
+		PageImages:    args.PageImages,
+		ImageInline:   args.ImageInline,
+		ImageDataURI:  args.ImageDataURI,
+
+		NativeImages:     args.NativeImages,
+		ClipNativeImages: args.ClipNativeImages,
+		AttemptOCR:       args.AttemptOCR,
+		OCRLang:          args.OCRLang,
+		TesseractPath:    args.TesseractPath,
+		TessDataDir:      args.TessDataDir,
+
+		NoInferHeadings: args.NoInferHeadings,
+	}
+}
+
+func newExtractionCache(skipImages bool) (*extractionCache, error) {
+	if args.CacheDir == "" {
+		return nil, nil
+	}
+
+	opts, err := json.Marshal(getExtractionOptions(skipImages))
+	if err != nil {
+		return nil, err
+	}
+
+	// The version is part of the key, since extraction may change between
+	// versions, as is whether cached metadata includes identifiers and
+	// cached pages include their text and thumbnails
+	key := fmt.Sprintf("%s\n%t\n%t\n%t\n", version, needsIdentifiers(), needsPageText(), needsThumbnails())
+	sum := sha256.Sum256(append([]byte(key), opts...))
+	dir := filepath.Join(args.CacheDir, hex.EncodeToString(sum[:])[:16])
+
+	for _, sub := range []string{"files", "pages", "images", "outlines"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	return &extractionCache{dir: dir}, nil
+}
+
+func (c *extractionCache) writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+func (c *extractionCache) readJSON(path string, v interface{}) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(data, v) == nil
+}
+
+// A file entry lists the fingerprints of its pages, in order, along with the
+// document's metadata.
+type cacheFileEntry struct {
+	Fingerprints []string          `json:"fingerprints"`
+	Document     *documentMetadata `json:"document"`
+}
+
+func (c *extractionCache) getFile(checksum string, imageOutputPath string) ([]*pdfutils.Annotation, *documentMetadata, bool) {
+	entry := cacheFileEntry{}
+	if !c.readJSON(filepath.Join(c.dir, "files", checksum+".json"), &entry) || entry.Document == nil {
+		return nil, nil, false
+	}
+
+	annots := []*pdfutils.Annotation{}
+
+	for _, fingerprint := range entry.Fingerprints {
+		pageAnnots, page, ok := c.getPage(fingerprint, imageOutputPath)
+		if !ok {
+			return nil, nil, false
+		}
+
+		if page != nil && len(pageAnnots) > 0 {
+			if entry.Document.pages == nil {
+				entry.Document.pages = map[int]*pageData{}
+			}
+
+			entry.Document.pages[pageAnnots[0].Page] = page
+		}
+
+		annots = append(annots, pageAnnots...)
+	}
+
+	return annots, entry.Document, true
+}
+
+func (c *extractionCache) putFile(checksum string, fingerprints []string, document *documentMetadata) error {
+	return c.writeJSON(filepath.Join(c.dir, "files", checksum+".json"), cacheFileEntry{fingerprints, document})
+}
+
+// Inferred outlines are keyed on the content of the pages they were inferred
+// from. The outline may be empty when no headings were found.
+func (c *extractionCache) getOutline(key string) ([]*pdfutils.OutlineEntry, bool) {
+	outline := []*pdfutils.OutlineEntry{}
+	if !c.readJSON(filepath.Join(c.dir, "outlines", key+".json"), &outline) {
+		return nil, false
+	}
+
+	return outline, true
+}
+
+func (c *extractionCache) putOutline(key string, outline []*pdfutils.OutlineEntry) error {
+	if outline == nil {
+		outline = []*pdfutils.OutlineEntry{}
+	}
+
+	return c.writeJSON(filepath.Join(c.dir, "outlines", key+".json"), outline)
+}
+
+// Images that are stored in the cache along with their annotation
+func getCachedImagePaths(annot *pdfutils.Annotation) []*string {
+	return []*string{&annot.ImagePath, &annot.PageImagePath}
+}
+
+// A page entry holds the annotations of a page, and what text output needs
+// from it
+type cachePageEntry struct {
+	Annotations []*pdfutils.Annotation `json:"annotations"`
+	Page        *pageData              `json:"page,omitempty"`
+}
+
+func (c *extractionCache) getPage(fingerprint string, imageOutputPath string) ([]*pdfutils.Annotation, *pageData, bool) {
+	entry := cachePageEntry{}
+	if !c.readJSON(filepath.Join(c.dir, "pages", fingerprint+".json"), &entry) || entry.Annotations == nil {
+		return nil, nil, false
+	}
+
+	annots := entry.Annotations
+
+	for _, annot := range annots {
+		for _, imagePath := range getCachedImagePaths(annot) {
+			if *imagePath == "" {
+				continue
+			}
+
+			name := *imagePath
+			*imagePath = fmt.Sprintf("%s/%s", imageOutputPath, name)
+
+			if args.NoWrite {
+				continue
+			}
+
+			if err := os.MkdirAll(imageOutputPath, os.ModePerm); err != nil {
+				return nil, nil, false
+			}
+
+			if err := copyFile(filepath.Join(c.dir, "images", fingerprint, name), *imagePath); err != nil {
+				return nil, nil, false
+			}
+		}
+	}
+
+	return annots, entry.Page, true
+}
+
+// Images are stored by name alone, since the same page may be written to a
+// different image output path on the next run.
+func (c *extractionCache) putPage(fingerprint string, annots []*pdfutils.Annotation, page *pageData) error {
+	stored := make([]*pdfutils.Annotation, len(annots))
+
+	for i, annot := range annots {
+		clone := *annot
+		paths := getCachedImagePaths(annot)
+
+		for j, imagePath := range getCachedImagePaths(&clone) {
+			if *imagePath == "" {
+				continue
+			}
+
+			*imagePath = filepath.Base(*imagePath)
+
+			if args.NoWrite {
+				continue
+			}
+
+			imageDir := filepath.Join(c.dir, "images", fingerprint)
+
+			if err := os.MkdirAll(imageDir, os.ModePerm); err != nil {
+				return err
+			}
+
+			if err := copyFile(*paths[j], filepath.Join(imageDir, *imagePath)); err != nil {
+				return err
+			}
+		}
+
+		stored[i] = &clone
+	}
+
+	return c.writeJSON(filepath.Join(c.dir, "pages", fingerprint+".json"), cachePageEntry{stored, page})
+}
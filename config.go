@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+const configName = "pdfannots2json"
+
+var configExts = []string{".yaml", ".yml", ".json"}
+
+// Finds the first config file with one of the supported extensions in dir
+func findConfigIn(dir string, name string) string {
+	for _, ext := range configExts {
+		path := filepath.Join(dir, name+ext)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// Returns the config files to load, lowest precedence first: the user config,
+// followed by the nearest project config found by walking up from the working
+// directory.
+func findConfigFiles() []string {
+	files := []string{}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		if path := findConfigIn(filepath.Join(dir, configName), "config"); path != "" {
+			files = append(files, path)
+		}
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return files
+	}
+
+	for {
+		if path := findConfigIn(dir, "."+configName); path != "" {
+			return append(files, path)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return files
+		}
+
+		dir = parent
+	}
+}
+
+func normalizeConfigKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "_", "-")
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("Error: unsupported config file %s. Supports .yaml, .yml, and .json", path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Error: invalid config file %s: %s", path, err)
+	}
+
+	return values, nil
+}
+
+type configValue struct {
+	value interface{}
+	path  string
+}
+
+// configResolver supplies flag values from config files. kong only consults
+// resolvers for flags that weren't set on the command line, so flags always
+// override the config. Files are loaded on first use, once --config and
+// --profile have been parsed.
+type configResolver struct {
+	loaded bool
+	values map[string]configValue
+	err    error
+}
+
+func (r *configResolver) Validate(app *kong.Application) error {
+	return nil
+}
+
+func (r *configResolver) set(path string, values map[string]interface{}, flags map[string]*kong.Flag) error {
+	for key, value := range values {
+		name := normalizeConfigKey(key)
+
+		if name == "profiles" {
+			continue
+		}
+
+		if _, ok := flags[name]; !ok || name == "config" || name == "profile" {
+			return fmt.Errorf("Error: unknown option %q in config file %s", key, path)
+		}
+
+		r.values[name] = configValue{value, path}
+	}
+
+	return nil
+}
+
+func (r *configResolver) load(ctx *kong.Context) error {
+	r.values = map[string]configValue{}
+
+	flags := map[string]*kong.Flag{}
+	configPath := ""
+	profile := ""
+
+	for _, flag := range ctx.Flags() {
+		flags[flag.Name] = flag
+
+		switch flag.Name {
+		case "config":
+			configPath, _ = ctx.FlagValue(flag).(string)
+		case "profile":
+			profile, _ = ctx.FlagValue(flag).(string)
+		}
+	}
+
+	files := []string{configPath}
+	if configPath == "" {
+		files = findConfigFiles()
+	}
+
+	// Profiles are applied once every file's base options are, so that the
+	// options of a profile from the user config aren't overridden by the
+	// base options of the project config
+	profileValues := []map[string]interface{}{}
+	profilePaths := []string{}
+
+	for _, path := range files {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		if err := r.set(path, values, flags); err != nil {
+			return err
+		}
+
+		if profile == "" {
+			continue
+		}
+
+		profiles := map[string]interface{}{}
+
+		for key, value := range values {
+			if normalizeConfigKey(key) != "profiles" {
+				continue
+			}
+
+			if profiles, _ = value.(map[string]interface{}); profiles == nil {
+				return fmt.Errorf("Error: profiles must be a map of profile names to options in config file %s", path)
+			}
+		}
+
+		if p, ok := profiles[profile]; ok {
+			pValues, ok := p.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("Error: profile %q must be a map of options in config file %s", profile, path)
+			}
+
+			profileValues = append(profileValues, pValues)
+			profilePaths = append(profilePaths, path)
+		}
+	}
+
+	for i, values := range profileValues {
+		if err := r.set(profilePaths[i], values, flags); err != nil {
+			return err
+		}
+	}
+
+	foundProfile := len(profileValues) > 0
+
+	if profile != "" && !foundProfile {
+		if len(files) == 0 {
+			return fmt.Errorf("Error: profile %q not found: no config file", profile)
+		}
+
+		return fmt.Errorf("Error: profile %q not found in %s", profile, strings.Join(files, ", "))
+	}
+
+	return nil
+}
+
+func isPathFlag(flag *kong.Flag) bool {
+	switch flag.Tag.Type {
+	case "path", "existingfile", "existingdir":
+		return true
+	}
+
+	return false
+}
+
+// Converts values decoded from YAML or JSON to a form kong's mappers accept.
+// Relative paths are resolved against the directory of the config file
+// rather than the working directory.
+func normalizeConfigValue(flag *kong.Flag, v configValue) interface{} {
+	switch value := v.value.(type) {
+	case time.Time:
+		return value.Format(time.RFC3339)
+	case string:
+		if isPathFlag(flag) && value != stdinPath && value != "" && !filepath.IsAbs(value) && !strings.HasPrefix(value, "~/") {
+			return filepath.Join(filepath.Dir(v.path), value)
+		}
+	}
+
+	return v.value
+}
+
+func (r *configResolver) Resolve(ctx *kong.Context, parent *kong.Path, flag *kong.Flag) (interface{}, error) {
+	if !r.loaded {
+		r.loaded = true
+		r.err = r.load(ctx)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	v, ok := r.values[flag.Name]
+	if !ok {
+		return nil, nil
+	}
+
+	return normalizeConfigValue(flag, v), nil
+}
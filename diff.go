@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+type diffCmd struct {
+	Old string `arg:"" name:"old" help:"Path to the original PDF, or a JSON export of its annotations" type:"path"`
+	New string `arg:"" name:"new" help:"Path to the revised PDF, or a JSON export of its annotations" type:"path"`
+}
+
+// JSON exports may either be a plain list of annotations, an envelope
+// holding them under "annotations", or the output of batch mode, either plain
+// or in an envelope, as long as it holds a single file.
+func readAnnotationsJSON(path string) ([]*pdfutils.Annotation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		items := []map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+
+		if len(items) > 0 && items[0]["annotations"] != nil {
+			files := []*fileResult{}
+			if err := json.Unmarshal(data, &files); err != nil {
+				return nil, err
+			}
+
+			return getBatchAnnotations(path, files)
+		}
+
+		annots := []*pdfutils.Annotation{}
+		err = json.Unmarshal(data, &annots)
+		return annots, err
+	}
+
+	wrapped := struct {
+		Annotations []*pdfutils.Annotation `json:"annotations"`
+		Files       []*fileResult          `json:"files"`
+	}{}
+
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, err
+	}
+
+	if wrapped.Files != nil {
+		return getBatchAnnotations(path, wrapped.Files)
+	}
+
+	if wrapped.Annotations == nil {
+		return nil, fmt.Errorf("Error: %s is not an export of annotations", path)
+	}
+
+	return wrapped.Annotations, nil
+}
+
+func getBatchAnnotations(path string, files []*fileResult) ([]*pdfutils.Annotation, error) {
+	if len(files) != 1 {
+		return nil, fmt.Errorf("Error: %s holds the annotations of %d files, but diff compares one file to another", path, len(files))
+	}
+
+	if files[0].Annotations == nil {
+		return []*pdfutils.Annotation{}, nil
+	}
+
+	return files[0].Annotations, nil
+}
+
+func loadDiffInput(path string) ([]*pdfutils.Annotation, error) {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return readAnnotationsJSON(path)
+	}
+
+	workers.acquire()
+	defer workers.release()
+
+	annots, _, err := extractAnnotations(path, "", args.ImageOutputPath)
+
+	return annots, err
+}
+
+func (c *diffCmd) Run() error {
+	if err := validateArgs(); err != nil {
+		return err
+	}
+
+	if args.Format != formatJSON {
+		return fmt.Errorf("Error: diff only supports --format=json")
+	}
+
+	// Both PDFs would otherwise save their images to the same paths. Images
+	// aren't compared, so none are saved, and their paths still match.
+	args.NoWrite = true
+	args.ImageInline = false
+
+	oldAnnots, err := loadDiffInput(c.Old)
+	if err != nil {
+		return err
+	}
+
+	newAnnots, err := loadDiffInput(c.New)
+	if err != nil {
+		return err
+	}
+
+	logOutput(pdfutils.DiffAnnotations(oldAnnots, newAnnots))
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/mgmeyers/go-fitz"
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+	"github.com/mgmeyers/unipdf/v3/model"
+)
+
+// A PDF opened by both unipdf and fitz. Files are read from disk as needed;
+// stdin and uploads are backed by a buffer.
+type pdfDocument struct {
+	reader  *model.PdfReader
+	fitzDoc *fitz.Document
+	file    *os.File
+	data    []byte
+	mu      sync.Mutex
+}
+
+func openPDF(inputPath string) (*pdfDocument, error) {
+	if inputPath == stdinPath {
+		data, err := readStdin()
+		if err != nil {
+			return nil, err
+		}
+
+		return openPDFBytes(data)
+	}
+
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		f.Close()
+		return nil, fmt.Errorf("Error: PDF is empty")
+	}
+
+	pdfReader, err := newPDFReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	fitzDoc, err := fitz.New(inputPath)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &pdfDocument{
+		reader:  pdfReader,
+		fitzDoc: fitzDoc,
+		file:    f,
+	}, nil
+}
+
+func openPDFBytes(data []byte) (*pdfDocument, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("Error: PDF is empty")
+	}
+
+	pdfReader, err := newPDFReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	fitzDoc, err := fitz.NewFromMemory(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pdfDocument{
+		reader:  pdfReader,
+		fitzDoc: fitzDoc,
+		data:    data,
+	}, nil
+}
+
+func newPDFReader(rs io.ReadSeeker) (*model.PdfReader, error) {
+	pdfReader, err := model.NewPdfReader(rs)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption := pdfReader.GetEncryptionMethod()
+	if encryption != "" {
+		success, err := pdfReader.Decrypt([]byte{})
+		if err != nil {
+			return nil, err
+		}
+
+		if !success {
+			return nil, fmt.Errorf("Error: PDF is encrypted, unable to decrypt")
+		}
+	}
+
+	return pdfReader, nil
+}
+
+// Returns up to the first n bytes of the PDF
+func (d *pdfDocument) readHead(n int) ([]byte, error) {
+	if d.file == nil {
+		if len(d.data) > n {
+			return d.data[:n], nil
+		}
+
+		return d.data, nil
+	}
+
+	buf := make([]byte, n)
+	read, err := d.file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return buf[:read], nil
+}
+
+func (d *pdfDocument) Close() error {
+	err := d.fitzDoc.Close()
+
+	if d.file != nil {
+		if ferr := d.file.Close(); err == nil {
+			err = ferr
+		}
+	}
+
+	return err
+}
+
+// Loads a page, filling in the boxes and rotation that the rest of the
+// extraction relies on. Returns nil when the page has no media box.
+func (d *pdfDocument) getPage(pageIndex int) (*model.PdfPage, error) {
+	page, err := d.reader.GetPage(pageIndex + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if page.MediaBox == nil {
+		mb := pdfutils.GetMediaBox(page)
+
+		if mb == nil {
+			return nil, nil
+		}
+
+		page.MediaBox = mb
+	}
+
+	if page.Rotate == nil {
+		var zero int64 = 0
+		page.Rotate = &zero
+	}
+
+	if page.CropBox == nil {
+		page.CropBox = page.MediaBox
+	}
+
+	return page, nil
+}
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/r2"
+	"github.com/mgmeyers/go-fitz"
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+	"github.com/mgmeyers/unipdf/v3/extractor"
+	"github.com/mgmeyers/unipdf/v3/model"
+	"golang.org/x/sync/errgroup"
+)
+
+func extractAnnotations(inputPath string, checksum string, imageOutputPath string) ([]*pdfutils.Annotation, *documentMetadata, error) {
+	skipImages := shouldSkipImages(imageOutputPath)
+
+	cache, err := newExtractionCache(skipImages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cache != nil && checksum != "" {
+		if annots, meta, ok := cache.getFile(checksum, imageOutputPath); ok {
+			meta.Path = inputPath
+			meta.SHA256 = checksum
+			if err := applyDocumentMetadata(annots, meta); err != nil {
+				return nil, nil, err
+			}
+
+			return annots, meta, nil
+		}
+	}
+
+	doc, err := openPDF(inputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer doc.Close()
+
+	annots, meta, err := extractDocumentAnnotations(doc, checksum, imageOutputPath, cache)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	meta.Path = inputPath
+	meta.SHA256 = checksum
+
+	if err := applyDocumentMetadata(annots, meta); err != nil {
+		return nil, nil, err
+	}
+
+	return annots, meta, nil
+}
+
+func extractDocumentAnnotations(
+	doc *pdfDocument,
+	checksum string,
+	imageOutputPath string,
+	cache *extractionCache,
+) ([]*pdfutils.Annotation, *documentMetadata, error) {
+	skipImages := shouldSkipImages(imageOutputPath)
+	pdfReader := doc.reader
+	fitzDoc := doc.fitzDoc
+
+	filter, err := newAnnotationFilter()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	imageTypes, err := getImageTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	numPages, err := pdfReader.GetNumPages()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pageLabels, err := pdfReader.GetPageLabels()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	collectedAnnotations := make([][]*pdfutils.Annotation, numPages)
+	fingerprints := make([]string, numPages)
+	pageDatas := make([]*pageData, numPages)
+	pageLines := make([][]*pdfutils.TextLine, numPages)
+	scannedPages := make([]bool, numPages)
+	g := new(errgroup.Group)
+	mu := sync.Mutex{}
+
+	pageLabelMap := pdfutils.GetPageLabelMap(numPages, pageLabels)
+	meta, err := getDocumentMetadata(doc, numPages, pageLabelMap, pageLabels != nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Headings are inferred from the text of the pages, which is kept as they
+	// are processed
+	inferHeadings := len(meta.Outline) == 0 && !args.NoInferHeadings
+
+	var selectedPages map[int]bool
+
+	if args.Pages != "" {
+		selectedPages, err = pdfutils.ParsePageRanges(args.Pages, numPages, pageLabelMap, args.PageLabels)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i := 0; i < numPages; i++ {
+		if selectedPages != nil && !selectedPages[i] {
+			continue
+		}
+
+		index := i
+		pageLabel := pdfutils.GetPageLabel(pageLabelMap, i)
+
+		workers.Go(g, func() error {
+			page, err := doc.getPage(index)
+			if err != nil || page == nil {
+				return err
+			}
+
+			mu.Lock()
+			annotations, err := page.GetAnnotations()
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+
+			if len(annotations) == 0 {
+				return nil
+			}
+
+			haveRectangles := false
+			haveImages := false
+			filtered := []*model.PdfAnnotation{}
+
+			for _, a := range annotations {
+				annotType := pdfutils.GetAnnotationType(a.GetContext())
+
+				if annotType == pdfutils.Unsupported {
+					continue
+				}
+
+				if !filter.keepPDFAnnotation(a, annotType, skipImages) {
+					continue
+				}
+
+				if annotType == pdfutils.Rectangle {
+					haveRectangles = true
+				}
+
+				if annotType == pdfutils.Rectangle || imageTypes[annotType] {
+					haveImages = true
+				}
+
+				filtered = append(filtered, a)
+			}
+
+			if len(filtered) == 0 {
+				return nil
+			}
+
+			fingerprint := ""
+
+			if cache != nil {
+				fingerprint = pdfutils.GetPageFingerprint(index, pageLabel, page, filtered)
+				fingerprints[index] = fingerprint
+
+				if annots, page, ok := cache.getPage(fingerprint, imageOutputPath); ok {
+					collectedAnnotations[index] = annots
+					pageDatas[index] = page
+					return nil
+				}
+			}
+
+			var pageImg image.Image
+			var pageSVG *string
+			var ocrImg image.Image
+
+			writeImages := !skipImages && (!args.NoWrite || args.ImageInline)
+			svg := args.ImageFormat == imageFormatSVG
+
+			if writeImages && ((haveImages && !svg) || args.PageImages) {
+				pageImg, err = fitzDoc.ImageDPI(index, float64(args.ImageDPI))
+				if err != nil {
+					return err
+				}
+			}
+
+			if writeImages && haveImages && svg {
+				s, err := fitzDoc.SVG(index)
+				if err != nil {
+					return err
+				}
+
+				pageSVG = &s
+			}
+
+			if haveRectangles && !skipImages {
+				if args.AttemptOCR {
+					ocrImg, err = fitzDoc.ImageDPI(index, 300.0)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			annots, txt, err := processAnnotations(
+				fitzDoc,
+				page,
+				pageLabel,
+				&pageImg,
+				pageSVG,
+				&ocrImg,
+				index,
+				filtered,
+				skipImages,
+				imageOutputPath,
+				filter,
+				imageTypes,
+			)
+			if err != nil {
+				return err
+			}
+
+			if inferHeadings {
+				pageLines[index] = pdfutils.GetTextLines(txt.Marks().Elements(), index+1)
+				scannedPages[index] = true
+			}
+
+			if args.PageImages && !skipImages && len(annots) > 0 {
+				if err := writePageImage(page, pageImg, index, annots, imageOutputPath); err != nil {
+					return err
+				}
+			}
+
+			var pd *pageData
+
+			if (needsPageText() || needsThumbnails()) && len(annots) > 0 {
+				pd = &pageData{}
+
+				if needsPageText() {
+					pd.Text = txt.Text()
+				}
+
+				if needsThumbnails() {
+					if err := addHTMLThumbnail(pd, fitzDoc, page, index, annots); err != nil {
+						return err
+					}
+				}
+			}
+
+			if cache != nil {
+				if err := cache.putPage(fingerprint, annots, pd); err != nil {
+					return err
+				}
+			}
+
+			collectedAnnotations[index] = annots
+			pageDatas[index] = pd
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	if inferHeadings {
+		lastPage := -1
+
+		for i, annots := range collectedAnnotations {
+			if len(annots) > 0 {
+				lastPage = i
+			}
+		}
+
+		if lastPage >= 0 {
+			outline, err := inferDocumentOutline(doc, lastPage, pageLines, scannedPages, cache)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if len(outline) > 0 {
+				meta.Outline = outline
+				meta.OutlineSource = "inferred"
+			}
+		}
+	}
+
+	if cache != nil && checksum != "" {
+		pageFingerprints := []string{}
+
+		for _, fingerprint := range fingerprints {
+			if fingerprint != "" {
+				pageFingerprints = append(pageFingerprints, fingerprint)
+			}
+		}
+
+		if err := cache.putFile(checksum, pageFingerprints, meta); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	filtered := []*pdfutils.Annotation{}
+
+	for i, annots := range collectedAnnotations {
+		if annots != nil && len(annots) > 0 {
+			filtered = append(filtered, annots...)
+
+			if pageDatas[i] != nil {
+				if meta.pages == nil {
+					meta.pages = map[int]*pageData{}
+				}
+
+				meta.pages[i+1] = pageDatas[i]
+			}
+		}
+	}
+
+	return filtered, meta, nil
+}
+
+const imageFormatSVG = "svg"
+
+// Images embedded with --image-inline need no output path
+func shouldSkipImages(imageOutputPath string) bool {
+	if args.ImageInline {
+		return false
+	}
+
+	return args.ImageBaseName == "" || imageOutputPath == ""
+}
+
+// Embedded page images are output once per page, in the document's metadata,
+// which only envelopes include
+func validateInlineImages() error {
+	if !args.ImageInline || !args.PageImages {
+		return nil
+	}
+
+	if args.Watch {
+		return fmt.Errorf("Error: watch mode can't embed --page-images with --image-inline")
+	}
+
+	// Inline page images are only written in the JSON envelope
+	if args.Format != formatJSON || !args.Envelope {
+		return fmt.Errorf("Error: --page-images with --image-inline requires --envelope and the json format")
+	}
+
+	return nil
+}
+
+func writeImageFile(imagePath string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(imagePath), os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.WriteFile(imagePath, data, 0644)
+}
+
+// Page images are always png, since they are mostly text
+func writePageImage(page *model.PdfPage, pageImg image.Image, pageIndex int, annots []*pdfutils.Annotation, imageOutputPath string) error {
+	imagePath := fmt.Sprintf("%s/%s-page-%d.png", imageOutputPath, args.ImageBaseName, pageIndex+1)
+
+	if !args.ImageInline {
+		for _, annot := range annots {
+			annot.PageImagePath = imagePath
+		}
+
+		if args.NoWrite {
+			return nil
+		}
+	}
+
+	var img image.Image = pdfutils.DrawAnnotationOverlays(page, pageImg, annots)
+
+	buf := &bytes.Buffer{}
+	if err := pdfutils.EncodeImage(buf, &img, "png", 0); err != nil {
+		return err
+	}
+
+	if !args.ImageInline {
+		return writeImageFile(imagePath, buf.Bytes())
+	}
+
+	// Embedded page images are only kept once per page. They are moved to
+	// the document's metadata once extraction is done.
+	if len(annots) > 0 {
+		annots[0].PageImageData = pdfutils.GetImageData(buf.Bytes(), "png", args.ImageDataURI)
+	}
+
+	return nil
+}
+
+var imageTypeNames = []string{
+	pdfutils.Highlight,
+	pdfutils.Strike,
+	pdfutils.Underline,
+	pdfutils.Text,
+}
+
+func getImageTypes() (map[string]bool, error) {
+	types := map[string]bool{}
+
+	for _, t := range args.ImageTypes {
+		t = strings.ToLower(strings.TrimSpace(t))
+		valid := false
+
+		// Rectangles are always saved as images
+		if t == pdfutils.Rectangle || t == pdfutils.Image {
+			continue
+		}
+
+		for _, it := range imageTypeNames {
+			if t == it {
+				valid = true
+				break
+			}
+		}
+
+		if !valid {
+			return nil, fmt.Errorf("Error: images can't be saved for %s annotations", t)
+		}
+
+		types[t] = true
+	}
+
+	return types, nil
+}
+
+func processAnnotations(
+	fitzDoc *fitz.Document,
+	page *model.PdfPage,
+	pageLabel string,
+	pageImg *image.Image,
+	pageSVG *string,
+	ocrImg *image.Image,
+	pageIndex int,
+	annotations []*model.PdfAnnotation,
+	skipImages bool,
+	imageOutputPath string,
+	filter *annotationFilter,
+	imageTypes map[string]bool,
+) ([]*pdfutils.Annotation, *extractor.PageText, error) {
+	annots := make([]*pdfutils.Annotation, len(annotations))
+	seenIDs := map[string]bool{}
+
+	ext, err := extractor.New(page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txt, _, _, err := ext.ExtractPageText()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nativeImages []extractor.ImageMark
+
+	if args.NativeImages && !skipImages && (!args.NoWrite || args.ImageInline) {
+		for _, a := range annotations {
+			if a == nil || pdfutils.GetAnnotationType(a.GetContext()) != pdfutils.Rectangle {
+				continue
+			}
+
+			images, err := ext.ExtractPageImages(nil)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			nativeImages = images.Images
+			break
+		}
+	}
+
+	text := txt.Text()
+	marks := txt.Marks().Elements()
+	markRects := []r2.Rect{}
+
+	for _, mark := range marks {
+		markRects = append(markRects, pdfutils.GetMarkRect(mark))
+	}
+
+	g := new(errgroup.Group)
+	mu := sync.Mutex{}
+
+	for index, annotation := range annotations {
+		annotation := annotation
+		index := index
+
+		if annotation == nil {
+			continue
+		}
+
+		workers.Go(g, func() error {
+			annotType := pdfutils.GetAnnotationType(annotation.GetContext())
+			if annotType == pdfutils.Unsupported {
+				return nil
+			}
+
+			date := pdfutils.GetAnnotationDate(annotation)
+			x, y := pdfutils.GetCoordinates(annotation)
+
+			mu.Lock()
+			id := pdfutils.GetAnnotationID(seenIDs, pageIndex, x, y, annotType)
+			mu.Unlock()
+
+			if !skipImages && annotType == pdfutils.Rectangle {
+				imgAnnot, err := pdfutils.HandleImageAnnot(pdfutils.ImageAnnotArgs{
+					Page:            page,
+					PageImg:         pageImg,
+					PageIndex:       pageIndex,
+					PageLabel:       pageLabel,
+					OCRImg:          ocrImg,
+					AttemptOCR:      args.AttemptOCR,
+					Annotation:      annotation,
+					X:               x,
+					Y:               y,
+					ID:              id,
+					Write:           !args.NoWrite && !args.ImageInline,
+					ImageOutputPath: imageOutputPath,
+					ImageBaseName:   args.ImageBaseName,
+					ImageFormat:     args.ImageFormat,
+					ImageQuality:    args.ImageQuality,
+					TessPath:        args.TesseractPath,
+					TessLang:        args.OCRLang,
+					TessDataDir:     args.TessDataDir,
+
+					NativeImages:     nativeImages,
+					ClipNativeImages: args.ClipNativeImages,
+					PageSVG:          pageSVG,
+					Inline:           args.ImageInline,
+					DataURI:          args.ImageDataURI,
+				})
+
+				if err != nil {
+					return err
+				}
+
+				annots[index] = imgAnnot
+				return nil
+			}
+
+			str := ""
+			fallbackStr := ""
+			offset := -1
+			top := 0
+
+			if annotType != pdfutils.Text {
+				annoRects := pdfutils.GetAnnotationRects(page, annotation)
+
+				if annoRects == nil {
+					return nil
+				}
+
+				for _, anno := range annoRects {
+					if !anno.IsValid() || anno.IsEmpty() {
+						return nil
+					}
+
+					_, o := pdfutils.GetBoundsFromAnnotMarks(anno, markRects)
+
+					if offset == -1 {
+						offset = o
+						top = int(math.Max(page.MediaBox.Height()-anno.Y.Hi, 0.0))
+					}
+
+					annotText, err := pdfutils.GetTextByAnnotBounds(fitzDoc, pageIndex, page, anno)
+					if err != nil {
+						return err
+					}
+
+					if str == "" {
+						str = annotText
+					} else if strings.HasSuffix(str, " ") {
+						str += annotText
+					} else {
+						str += " " + annotText
+					}
+
+					fallback := pdfutils.GetFallbackText(text, anno, markRects, marks)
+
+					if fallbackStr == "" {
+						fallbackStr = fallback
+					} else if strings.HasSuffix(fallbackStr, " ") {
+						fallbackStr += fallback
+					} else {
+						fallbackStr += " " + fallback
+					}
+				}
+			} else {
+				offset = pdfutils.GetClosestMark(x, y, markRects)
+				top = int(math.Max(page.MediaBox.Height()-y, 0.0))
+			}
+
+			comment := getAnnotationComment(annotation)
+
+			annotatedText := str
+
+			if pdfutils.ShouldUseFallback(str, fallbackStr) {
+				annotatedText = fallbackStr
+			}
+
+			builtAnnot := &pdfutils.Annotation{
+				AnnotatedText: pdfutils.DeHyphen(pdfutils.CondenseSpaces(pdfutils.ExpandLigatures(annotatedText))),
+				Author:        pdfutils.GetAnnotationAuthor(annotation),
+				Color:         pdfutils.GetAnnotationColor(annotation),
+				ColorCategory: pdfutils.GetAnnotationColorCategory(annotation),
+				Comment:       comment,
+				Name:          pdfutils.GetAnnotationName(annotation),
+				Type:          annotType,
+				Page:          pageIndex + 1,
+				PageLabel:     pageLabel,
+				Quads:         pdfutils.GetAnnotationQuads(page, annotation),
+				Rect:          pdfutils.GetAnnotationRect(annotation),
+				X:             x,
+				Y:             y,
+				ID:            id,
+				SortIndex:     pdfutils.GetAnnotationSortKey(pageIndex, offset, top),
+			}
+
+			if date != nil {
+				builtAnnot.Date = date.Format(time.RFC3339)
+			}
+
+			if !filter.keepAnnotation(builtAnnot) {
+				return nil
+			}
+
+			if !skipImages && imageTypes[annotType] {
+				rects := builtAnnot.Quads
+				if len(rects) == 0 && len(builtAnnot.Rect) == 4 {
+					rects = [][]float64{builtAnnot.Rect}
+				}
+
+				if !args.ImageInline {
+					builtAnnot.ImagePath = fmt.Sprintf("%s/%s-%s.%s", imageOutputPath, args.ImageBaseName, id, args.ImageFormat)
+				}
+
+				if !args.NoWrite || args.ImageInline {
+					data, err := pdfutils.EncodeAnnotationImage(page, pageImg, pageSVG, rects, args.ImagePadding, args.ImageFormat, args.ImageQuality)
+					if err != nil {
+						return err
+					}
+
+					if args.ImageInline {
+						builtAnnot.ImageData = pdfutils.GetImageData(data, args.ImageFormat, args.ImageDataURI)
+					} else if err := writeImageFile(builtAnnot.ImagePath, data); err != nil {
+						return err
+					}
+				}
+			}
+
+			annots[index] = builtAnnot
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	filtered := []*pdfutils.Annotation{}
+
+	for _, annot := range annots {
+		if annot != nil {
+			filtered = append(filtered, annot)
+		}
+	}
+
+	sort.Sort(pdfutils.BySortIndex(filtered))
+
+	return filtered, txt, nil
+}
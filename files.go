@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const stdinPath = "-"
+
+var stdinOnce sync.Once
+var stdinData []byte
+var stdinErr error
+
+// Stdin can only be read once, so it is buffered for every later use.
+func readStdin() ([]byte, error) {
+	stdinOnce.Do(func() {
+		stdinData, stdinErr = io.ReadAll(os.Stdin)
+	})
+
+	return stdinData, stdinErr
+}
+
+func getFileChecksum(path string) (string, error) {
+	if path == stdinPath {
+		data, err := readStdin()
+		if err != nil {
+			return "", err
+		}
+
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Writes to a temporary file in the same folder and renames it into place,
+// so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	var mode os.FileMode = 0644
+
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
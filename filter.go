@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+	"github.com/mgmeyers/unipdf/v3/model"
+)
+
+var filterTypes = []string{
+	pdfutils.Highlight,
+	pdfutils.Strike,
+	pdfutils.Underline,
+	pdfutils.Text,
+	pdfutils.Rectangle,
+	pdfutils.Image,
+}
+
+// Filters are split in two: everything that can be read from the PDF
+// annotation itself is checked before any text extraction, rendering, or OCR
+// takes place. Only --match needs the annotated text, and is checked once it
+// has been extracted.
+type annotationFilter struct {
+	types   map[string]bool
+	colors  []string
+	authors []string
+	match   *regexp.Regexp
+}
+
+func newAnnotationFilter() (*annotationFilter, error) {
+	f := &annotationFilter{}
+
+	if len(args.Types) > 0 {
+		f.types = map[string]bool{}
+
+		for _, t := range args.Types {
+			t = strings.ToLower(strings.TrimSpace(t))
+			valid := false
+
+			for _, ft := range filterTypes {
+				if t == ft {
+					valid = true
+					break
+				}
+			}
+
+			if !valid {
+				return nil, fmt.Errorf("Error: %s is not a supported annotation type", t)
+			}
+
+			// Rectangles are exported as images, so accept either name
+			if t == pdfutils.Image {
+				t = pdfutils.Rectangle
+			}
+
+			f.types[t] = true
+		}
+	}
+
+	for _, c := range args.Colors {
+		f.colors = append(f.colors, strings.ToLower(strings.TrimSpace(c)))
+	}
+
+	for _, a := range args.Author {
+		f.authors = append(f.authors, strings.ToLower(strings.TrimSpace(a)))
+	}
+
+	if args.Match != "" {
+		re, err := regexp.Compile(args.Match)
+		if err != nil {
+			return nil, fmt.Errorf("Error: invalid --match pattern: %s", err)
+		}
+
+		f.match = re
+	}
+
+	return f, nil
+}
+
+func (f *annotationFilter) keepColor(annotation *model.PdfAnnotation) bool {
+	if len(f.colors) == 0 {
+		return true
+	}
+
+	color := pdfutils.GetAnnotationColor(annotation)
+	category := strings.ToLower(pdfutils.GetAnnotationColorCategory(annotation))
+
+	for _, c := range f.colors {
+		if c == color || c == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *annotationFilter) keepAuthor(annotation *model.PdfAnnotation) bool {
+	if len(f.authors) == 0 {
+		return true
+	}
+
+	author := strings.ToLower(pdfutils.GetAnnotationAuthor(annotation))
+
+	for _, a := range f.authors {
+		if a == author {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getAnnotationComment(annotation *model.PdfAnnotation) string {
+	if annotation.Contents == nil {
+		return ""
+	}
+
+	return pdfutils.RemoveNul(annotation.Contents.String())
+}
+
+// Checks everything that doesn't require the annotated text. Rectangles have
+// no annotated text, so --match is checked against their comment here,
+// before they are rendered.
+func (f *annotationFilter) keepPDFAnnotation(annotation *model.PdfAnnotation, annotType string, skipImages bool) bool {
+	if f.types != nil && !f.types[annotType] {
+		return false
+	}
+
+	date := pdfutils.GetAnnotationDate(annotation)
+	if date != nil && date.Before(args.IgnoreBefore) {
+		return false
+	}
+
+	if date != nil && !args.IgnoreAfter.IsZero() && date.After(args.IgnoreAfter) {
+		return false
+	}
+
+	comment := getAnnotationComment(annotation)
+
+	if args.HasComment && strings.TrimSpace(comment) == "" {
+		return false
+	}
+
+	if f.match != nil && annotType == pdfutils.Rectangle && !skipImages && !f.match.MatchString(comment) {
+		return false
+	}
+
+	return f.keepColor(annotation) && f.keepAuthor(annotation)
+}
+
+func (f *annotationFilter) keepAnnotation(annot *pdfutils.Annotation) bool {
+	if f.match == nil || annot.Type == pdfutils.Image {
+		return true
+	}
+
+	return f.match.MatchString(annot.AnnotatedText) || f.match.MatchString(annot.Comment)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"math"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mgmeyers/go-fitz"
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+	"github.com/mgmeyers/unipdf/v3/model"
+)
+
+const (
+	htmlThumbnailDPI     = 60
+	htmlThumbnailQuality = 75
+)
+
+type htmlOverlay struct {
+	ID     string
+	Type   string
+	Color  string
+	Group  string
+	Left   float64
+	Top    float64
+	Width  float64
+	Height float64
+}
+
+type htmlAnnotation struct {
+	*pdfutils.Annotation
+	Group string
+	Image template.URL
+}
+
+type htmlPage struct {
+	Page        int
+	Label       string
+	Thumbnail   template.URL
+	Overlays    []*htmlOverlay
+	Annotations []*htmlAnnotation
+}
+
+type htmlDocument struct {
+	Title string
+	Path  string
+	Pages []*htmlPage
+}
+
+type htmlReport struct {
+	Types     []string
+	Colors    []string
+	Documents []*htmlDocument
+}
+
+// Annotations without a color are filtered as their own group
+func getHTMLColorGroup(annot *pdfutils.Annotation) string {
+	if annot.ColorCategory == "" {
+		return "None"
+	}
+
+	return annot.ColorCategory
+}
+
+func getDataURL(data []byte, mimeType string) template.URL {
+	return template.URL("data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+// Images are embedded so that the report can be sent on its own
+func getHTMLImage(annot *pdfutils.Annotation) template.URL {
+	if uri := getImageDataURI(annot); uri != "" {
+		return template.URL(uri)
+	}
+
+	path := annot.ImagePath
+	if path == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = "image/jpeg"
+	}
+
+	return getDataURL(data, mimeType)
+}
+
+// Renders a thumbnail of an annotated page during extraction, and positions
+// the annotations' quads over it as a percentage of the page's size.
+func addHTMLThumbnail(pd *pageData, fitzDoc *fitz.Document, page *model.PdfPage, pageIndex int, annots []*pdfutils.Annotation) error {
+	img, err := fitzDoc.ImageDPI(pageIndex, htmlThumbnailDPI)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := pdfutils.EncodeImage(buf, &img, "jpg", htmlThumbnailQuality); err != nil {
+		return err
+	}
+
+	pd.Thumbnail = string(getDataURL(buf.Bytes(), "image/jpeg"))
+	pd.Overlays = map[string][][]float64{}
+
+	size := img.Bounds().Size()
+	aspect := float64(size.Y) / float64(size.X)
+
+	for _, a := range annots {
+		rects := a.Quads
+		if len(rects) == 0 && len(a.Rect) == 4 {
+			rects = [][]float64{a.Rect}
+		}
+
+		for _, r := range rects {
+			imageRect, width := pdfutils.GetPageImageRect(page, append([]float64{}, r...))
+			height := width * aspect
+
+			left := math.Min(imageRect[0], imageRect[2])
+			top := math.Min(imageRect[1], imageRect[3])
+
+			pd.Overlays[a.ID] = append(pd.Overlays[a.ID], []float64{
+				math.Round(left/width*1000) / 10,
+				math.Round(top/height*1000) / 10,
+				math.Round(math.Abs(imageRect[2]-imageRect[0])/width*1000) / 10,
+				math.Round(math.Abs(imageRect[3]-imageRect[1])/height*1000) / 10,
+			})
+		}
+	}
+
+	return nil
+}
+
+// Adds the thumbnails rendered during extraction to the report's pages
+func addHTMLThumbnails(doc *fileResult, pages []*htmlPage) {
+	if doc.meta == nil {
+		return
+	}
+
+	for _, p := range pages {
+		pd := doc.meta.pages[p.Page]
+		if pd == nil {
+			continue
+		}
+
+		p.Thumbnail = template.URL(pd.Thumbnail)
+
+		for _, a := range p.Annotations {
+			for _, r := range pd.Overlays[a.ID] {
+				p.Overlays = append(p.Overlays, &htmlOverlay{
+					ID:     a.ID,
+					Type:   a.Type,
+					Color:  a.Color,
+					Group:  a.Group,
+					Left:   r[0],
+					Top:    r[1],
+					Width:  r[2],
+					Height: r[3],
+				})
+			}
+		}
+	}
+}
+
+func writeHTML(w *bytes.Buffer, docs []*fileResult) error {
+	report := &htmlReport{}
+	types := map[string]bool{}
+	colors := map[string]bool{}
+
+	for _, doc := range docs {
+		hDoc := &htmlDocument{Title: getDocumentTitle(doc), Path: doc.Path}
+		var page *htmlPage
+
+		for _, annot := range doc.Annotations {
+			if page == nil || page.Page != annot.Page {
+				page = &htmlPage{Page: annot.Page, Label: annot.PageLabel}
+				hDoc.Pages = append(hDoc.Pages, page)
+			}
+
+			a := &htmlAnnotation{
+				Annotation: annot,
+				Group:      getHTMLColorGroup(annot),
+				Image:      getHTMLImage(annot),
+			}
+
+			page.Annotations = append(page.Annotations, a)
+			types[annot.Type] = true
+			colors[a.Group] = true
+		}
+
+		addHTMLThumbnails(doc, hDoc.Pages)
+
+		report.Documents = append(report.Documents, hDoc)
+	}
+
+	for t := range types {
+		report.Types = append(report.Types, t)
+	}
+
+	for c := range colors {
+		report.Colors = append(report.Colors, c)
+	}
+
+	sort.Strings(report.Types)
+	sort.Strings(report.Colors)
+
+	return htmlTemplate.Execute(w, report)
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{range $i, $d := .Documents}}{{if $i}}, {{end}}{{$d.Title}}{{else}}Annotations{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; color: #222; background: #f6f6f6; }
+header { position: sticky; top: 0; background: #fff; border-bottom: 1px solid #ddd; padding: 0.75em 1.5em; z-index: 1; }
+header fieldset { display: inline-block; border: none; margin: 0 1.5em 0 0; padding: 0; }
+header legend { font-weight: bold; float: left; margin-right: 0.5em; }
+header label { margin-right: 0.75em; text-transform: capitalize; }
+main { padding: 1em 1.5em; }
+.page { display: flex; gap: 1.5em; align-items: flex-start; background: #fff; border: 1px solid #ddd; border-radius: 4px; padding: 1em; margin: 1em 0; }
+.thumbnail { position: relative; flex: 0 0 260px; }
+.thumbnail img { display: block; width: 100%; border: 1px solid #ccc; }
+.overlay { position: absolute; opacity: 0.45; mix-blend-mode: multiply; }
+.overlay.rectangle, .overlay.image, .overlay.text { background: none !important; border: 2px solid; opacity: 0.9; }
+.annotations { flex: 1; }
+.annotations h2 { margin: 0 0 0.25em; font-size: 1.1em; }
+.annotations ul { list-style: none; margin: 0; padding: 0; }
+.annotation { border-bottom: 1px solid #eee; padding: 0.5em 0; }
+.annotation:last-child { border-bottom: none; }
+.meta { font-size: 0.85em; color: #666; }
+.swatch { display: inline-block; width: 0.9em; height: 0.9em; border-radius: 2px; vertical-align: middle; margin-right: 0.4em; border: 1px solid rgba(0, 0, 0, 0.2); }
+blockquote { margin: 0.4em 0; padding-left: 0.75em; border-left: 3px solid #ccc; }
+.annotation img { max-width: 100%; }
+.hidden { display: none !important; }
+</style>
+</head>
+<body>
+<header>
+<fieldset id="types"><legend>Type</legend>{{range .Types}}<label><input type="checkbox" value="{{.}}" checked> {{.}}</label>{{end}}</fieldset>
+<fieldset id="colors"><legend>Color</legend>{{range .Colors}}<label><input type="checkbox" value="{{.}}" checked> {{.}}</label>{{end}}</fieldset>
+</header>
+<main>
+{{range .Documents}}<section class="document">
+<h1>{{.Title}}</h1>
+<p class="meta">{{.Path}}</p>
+{{range .Pages}}<div class="page">
+<div class="thumbnail">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="Page {{.Label}}">{{end}}
+{{range .Overlays}}<div class="overlay {{.Type}}" data-id="{{.ID}}" data-type="{{.Type}}" data-color="{{.Group}}" style="left: {{.Left}}%; top: {{.Top}}%; width: {{.Width}}%; height: {{.Height}}%; background: {{.Color}}; border-color: {{.Color}};"></div>
+{{end}}</div>
+<div class="annotations">
+<h2>Page {{.Label}}</h2>
+<ul>
+{{range .Annotations}}<li class="annotation" id="{{.ID}}" data-type="{{.Type}}" data-color="{{.Group}}">
+<div class="meta"><span class="swatch" style="background: {{.Color}};"></span>{{.Type}}{{if .Author}} · {{.Author}}{{end}}{{if .Date}} · {{.Date}}{{end}}</div>
+{{if .Image}}<img src="{{.Image}}" alt="">{{end}}
+{{if .AnnotatedText}}<blockquote>{{.AnnotatedText}}</blockquote>{{end}}
+{{if .OCRText}}<blockquote>{{.OCRText}}</blockquote>{{end}}
+{{if .Comment}}<p>{{.Comment}}</p>{{end}}
+</li>
+{{end}}</ul>
+</div>
+</div>
+{{end}}</section>
+{{end}}</main>
+<script>
+(function () {
+  var inputs = document.querySelectorAll("header input");
+
+  function checked(id) {
+    var values = {};
+    document.querySelectorAll("#" + id + " input:checked").forEach(function (input) {
+      values[input.value] = true;
+    });
+    return values;
+  }
+
+  function update() {
+    var types = checked("types");
+    var colors = checked("colors");
+
+    document.querySelectorAll("[data-type]").forEach(function (el) {
+      el.classList.toggle("hidden", !types[el.dataset.type] || !colors[el.dataset.color]);
+    });
+
+    document.querySelectorAll(".page").forEach(function (page) {
+      page.classList.toggle("hidden", !page.querySelector(".annotation:not(.hidden)"));
+    });
+  }
+
+  inputs.forEach(function (input) {
+    input.addEventListener("change", update);
+  });
+})();
+</script>
+</body>
+</html>
+`))
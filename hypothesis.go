@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Hypothesis stores 32 characters on either side of the quote
+const hypothesisContextLength = 32
+
+type hypothesisSelector struct {
+	Type   string `json:"type"`
+	Exact  string `json:"exact,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+	Index  *int   `json:"index,omitempty"`
+	Label  string `json:"label,omitempty"`
+}
+
+type hypothesisTarget struct {
+	Source   string                `json:"source"`
+	Selector []*hypothesisSelector `json:"selector"`
+}
+
+type hypothesisLink struct {
+	Href string `json:"href"`
+}
+
+type hypothesisDocument struct {
+	Title    []string            `json:"title"`
+	Link     []*hypothesisLink   `json:"link"`
+	Highwire map[string][]string `json:"highwire,omitempty"`
+}
+
+type hypothesisAnnotation struct {
+	ID       string              `json:"id"`
+	Created  string              `json:"created,omitempty"`
+	Updated  string              `json:"updated,omitempty"`
+	URI      string              `json:"uri"`
+	Text     string              `json:"text"`
+	Tags     []string            `json:"tags"`
+	Document *hypothesisDocument `json:"document"`
+	Target   []*hypothesisTarget `json:"target"`
+}
+
+type hypothesisExport struct {
+	ExportDate    string                  `json:"export_date"`
+	ClientVersion string                  `json:"client_version"`
+	Annotations   []*hypothesisAnnotation `json:"annotations"`
+}
+
+func getHypothesisQuote(pageText string, text string) *hypothesisSelector {
+	selector := &hypothesisSelector{Type: "TextQuoteSelector", Exact: strings.Join(strings.Fields(text), " ")}
+
+	before, exact, after, ok := getSentenceContext(pageText, text)
+	if !ok {
+		return selector
+	}
+
+	selector.Exact = exact
+
+	if runes := []rune(before); len(runes) > hypothesisContextLength {
+		before = string(runes[len(runes)-hypothesisContextLength:])
+	}
+
+	if runes := []rune(after); len(runes) > hypothesisContextLength {
+		after = string(runes[:hypothesisContextLength])
+	}
+
+	selector.Prefix = before
+	selector.Suffix = after
+
+	return selector
+}
+
+// Hypothesis identifies PDFs by the fingerprint PDF.js computes
+func getHypothesisAnnotations(doc *fileResult) ([]*hypothesisAnnotation, error) {
+	fingerprint := ""
+	if doc.meta != nil {
+		fingerprint = doc.meta.Fingerprint
+	}
+
+	uri := "urn:x-pdf:" + fingerprint
+	document := &hypothesisDocument{
+		Title: []string{getDocumentTitle(doc)},
+		Link:  []*hypothesisLink{{Href: uri}},
+	}
+
+	if doc.meta != nil && doc.meta.Identifiers != nil && doc.meta.Identifiers.DOI != "" {
+		document.Highwire = map[string][]string{"doi": {doc.meta.Identifiers.DOI}}
+	}
+
+	annots := []*hypothesisAnnotation{}
+
+	for _, annot := range doc.Annotations {
+		comment := strings.TrimSpace(annot.Comment)
+		if annot.AnnotatedText == "" && comment == "" {
+			continue
+		}
+
+		index := annot.Page - 1
+		selectors := []*hypothesisSelector{}
+
+		if annot.AnnotatedText != "" {
+			selectors = append(selectors, getHypothesisQuote(getDocumentPageText(doc, annot.Page), annot.AnnotatedText))
+		}
+
+		selectors = append(selectors, &hypothesisSelector{Type: "PageSelector", Index: &index, Label: annot.PageLabel})
+
+		tags := []string{}
+		if annot.ColorCategory != "" {
+			tags = append(tags, strings.ToLower(annot.ColorCategory))
+		}
+
+		// Annotation IDs are only unique within their document
+		annots = append(annots, &hypothesisAnnotation{
+			ID:       fingerprint + "-" + annot.ID,
+			Created:  annot.Date,
+			Updated:  annot.Date,
+			URI:      uri,
+			Text:     comment,
+			Tags:     tags,
+			Document: document,
+			Target:   []*hypothesisTarget{{Source: uri, Selector: selectors}},
+		})
+	}
+
+	return annots, nil
+}
+
+// Writes the annotations in the format of Hypothesis' JSON export, which its
+// client can import. Annotations without text or a comment are skipped, as
+// Hypothesis can't anchor shapes.
+func writeHypothesis(w *bytes.Buffer, docs []*fileResult) error {
+	export := &hypothesisExport{
+		ExportDate:    time.Now().UTC().Format(time.RFC3339),
+		ClientVersion: "pdfannots2json " + version,
+		Annotations:   []*hypothesisAnnotation{},
+	}
+
+	for _, doc := range docs {
+		annots, err := getHypothesisAnnotations(doc)
+		if err != nil {
+			return err
+		}
+
+		export.Annotations = append(export.Annotations, annots...)
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w.Write(data)
+	w.WriteString("\n")
+
+	return nil
+}
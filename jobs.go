@@ -0,0 +1,45 @@
+package main
+
+import (
+	"golang.org/x/sync/errgroup"
+)
+
+// PDFs, server requests, and the pages and annotations they process share a
+// single limit of --jobs goroutines. A PDF or request holds a slot while it
+// runs. The work it splits off only takes a slot when one is free, and
+// otherwise runs in the goroutine that started it, so a PDF never waits on
+// slots held by other PDFs to finish its own pages.
+type workerLimit struct {
+	sem chan struct{}
+}
+
+var workers *workerLimit
+
+func newWorkerLimit(jobs int) *workerLimit {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	return &workerLimit{sem: make(chan struct{}, jobs)}
+}
+
+func (l *workerLimit) acquire() {
+	l.sem <- struct{}{}
+}
+
+func (l *workerLimit) release() {
+	<-l.sem
+}
+
+func (l *workerLimit) Go(g *errgroup.Group, fn func() error) {
+	select {
+	case l.sem <- struct{}{}:
+		g.Go(func() error {
+			defer l.release()
+			return fn()
+		})
+	default:
+		err := fn()
+		g.Go(func() error { return err })
+	}
+}
@@ -3,53 +3,119 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"image"
-	"io"
 	"log"
-	"math"
 	"os"
-	"sort"
-	"strconv"
+	"runtime"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/alecthomas/kong"
-	"github.com/golang/geo/r2"
-	"github.com/mgmeyers/go-fitz"
 	"github.com/mgmeyers/pdfannots2json/pdfutils"
-	"github.com/mgmeyers/unipdf/v3/extractor"
-	"github.com/mgmeyers/unipdf/v3/model"
-	"golang.org/x/sync/errgroup"
 )
 
 const version = "v1.0.15"
 
 var args struct {
 	Version      kong.VersionFlag `short:"v" help:"Display the current version of pdfannots2json"`
+	Config       string           `type:"path" help:"Read options from this YAML or JSON config file instead of searching for .pdfannots2json.yaml and the user config. TOML is not supported"`
+	Profile      string           `help:"Apply the options of this profile from the config file"`
 	IgnoreBefore time.Time        `short:"b" help:"Ignore annotations added before this date. Must be ISO 8601 formatted"`
-	InputPDF     string           `arg:"" name:"input" help:"Path to input PDF" type:"path"`
+	IgnoreAfter  time.Time        `short:"a" help:"Ignore annotations added after this date. Must be ISO 8601 formatted"`
+	Output       string           `type:"path" help:"Write output to this file instead of stdout. The file is replaced atomically, except in watch mode where events are appended"`
+
+	Pages         string `short:"p" help:"Only extract annotations from these pages, eg. '1-10,15,20-'. Accepts page numbers or page labels"`
+	PageLabels    bool   `help:"Treat numbers in --pages as page labels rather than physical page numbers"`
+	Envelope      bool   `help:"Wrap the output in an object with the document's metadata and the options used to extract it"`
+	SchemaVersion int    `default:"${schemaVersion}" help:"Version of the JSON output schema to expect. Fails if this version of pdfannots2json outputs a different one"`
+	Format        string `enum:"json,markdown,org,html,sqlite,anki,readwise-csv,hypothesis" default:"json" help:"Output format. Supports json, markdown, org, html, sqlite, anki, readwise-csv, and hypothesis"`
+	DB            string `type:"path" help:"SQLite database to write to with --format=sqlite. It is created if it doesn't exist. Requires the sqlite3 command line shell, built with FTS5"`
+	SQLitePath    string `default:"sqlite3" help:"Path to the sqlite3 executable used by --format=sqlite. It must be built with FTS5, as it is by default"`
+	AnkiCards     string `enum:"basic,cloze" default:"basic" help:"Cards to create with --format=anki. Supports basic, from highlights with a comment, and cloze, from every highlight"`
+	AnkiMedia     string `type:"path" help:"Copy the images of rectangle annotations to this folder with --format=anki, eg. Anki's collection.media folder"`
+	Citekey       string `help:"Citation key of the document, added to each annotation and used in citations. Overrides the key found with --bib"`
+
+	NoInferHeadings bool   `help:"Do not infer section headings from font sizes when the PDF has no outline"`
+	Bib             string `type:"existingfile" help:"BibTeX or CSL-JSON file to look up the document's DOI, arXiv ID, or ISBN in. The matching entry's key is added to each annotation as citekey"`
+
+	// Filters
+	Types      []string `short:"t" help:"Only include these annotation types, eg. 'highlight,underline'. Supports highlight, strike, underline, text, and rectangle"`
+	Colors     []string `short:"c" help:"Only include annotations of these colors. Accepts hex colors, eg. '#ffff7f', or color categories, eg. 'yellow,green'"`
+	Author     []string `help:"Only include annotations by these authors"`
+	HasComment bool     `help:"Only include annotations with a comment"`
+	Match      string   `short:"m" help:"Only include annotations whose annotated text or comment matches this regular expression"`
+
+	// Commands
+	Extract extractCmd `cmd:"" default:"withargs" help:"Extract annotations from PDFs. This is the default command"`
+	Diff    diffCmd    `cmd:"" help:"Compare the annotations of two versions of a PDF"`
+	Serve   serveCmd   `cmd:"" help:"Run a local HTTP server exposing extraction, page rendering, and OCR as JSON endpoints"`
+	Schema  schemaCmd  `cmd:"" help:"Print the JSON Schema of the output for --schema-version"`
+
+	// Batch
+	Recursive bool `short:"r" help:"Search directories for PDFs recursively"`
+	Jobs      int  `short:"j" default:"${jobs}" help:"Number of PDFs and pages to process concurrently"`
+
+	// Watch
+	Watch         bool          `help:"Keep running and output annotation changes as NDJSON whenever an input PDF is modified"`
+	WatchInterval time.Duration `default:"1s" help:"How often to check the inputs for changes in watch mode"`
+	WatchSettle   time.Duration `default:"500ms" help:"How long a modified PDF must remain unchanged before it is processed in watch mode"`
+
+	// Cache
+	CacheDir string `type:"path" help:"Cache extracted annotations and images in this folder. Only pages that changed since the last run are reprocessed"`
 
 	// Images
-	NoWrite         bool   `short:"w" help:"Do not save images to disk"`
-	ImageOutputPath string `short:"o" type:"path" help:"Output path of image annotations"`
-	ImageBaseName   string `short:"n" default:"annot" help:"Base name of saved images"`
-	ImageFormat     string `short:"f" enum:"jpg,png" default:"jpg" help:"Image format. Supports png and jpg"`
-	ImageDPI        int    `short:"d" default:"120" help:"Image DPI"`
-	ImageQuality    int    `short:"q" default:"90" help:"Image quality. Only applies to jpg images"`
-	AttemptOCR      bool   `short:"e" help:"Attempt to extract text from images. tesseract-ocr must be installed on your system"`
-	OCRLang         string `short:"l" default:"eng" help:"Set the OCR language. Supports multiple languages, eg. 'eng+deu'. The desired languages must be installed"`
-	TesseractPath   string `default:"tesseract" help:"Absolute path to the tesseract executable"`
-	TessDataDir     string `help:"Absolute path to the tesseract data folder"`
+	NoWrite          bool     `short:"w" help:"Do not save images to disk"`
+	ImageOutputPath  string   `short:"o" type:"path" help:"Output path of image annotations. In batch mode, images are saved to a subfolder per PDF"`
+	ImageBaseName    string   `short:"n" default:"annot" help:"Base name of saved images"`
+	ImageFormat      string   `short:"f" enum:"jpg,png,svg" default:"jpg" help:"Image format. Supports png, jpg, and svg"`
+	ImageDPI         int      `short:"d" default:"120" help:"Image DPI"`
+	ImageQuality     int      `short:"q" default:"90" help:"Image quality. Only applies to jpg images"`
+	ImageTypes       []string `help:"Also save images of these annotation types, cropped from the page, eg. 'highlight,underline'. Supports highlight, strike, underline, and text"`
+	ImagePadding     float64  `default:"2" help:"Padding around images saved with --image-types, in points"`
+	NativeImages     bool     `help:"Save the image embedded under rectangle annotations at its own resolution, instead of a render of the page. Falls back to the render for vector graphics. Unclipped JPEGs saved as jpg keep their original data. Other images are decoded and saved in --image-format as grayscale or RGB, so CMYK, ICC based, and indexed colors are converted"`
+	ClipNativeImages bool     `help:"Clip images saved with --native-images to the annotation's rectangle"`
+	PageImages       bool     `help:"Also save a png of each annotated page with its annotations drawn over it, linked from each annotation as pageImagePath"`
+	ImageInline      bool     `help:"Embed images in the output as base64 instead of saving them to disk, in each annotation's imageData, and for --page-images, once per page in the envelope's document.pageImages, which needs --envelope and the json format. No image output path is needed"`
+	ImageDataURI     bool     `help:"Embed images as data: URIs rather than plain base64 with --image-inline"`
+	AttemptOCR       bool     `short:"e" help:"Attempt to extract text from images. tesseract-ocr must be installed on your system"`
+	OCRLang          string   `short:"l" default:"eng" help:"Set the OCR language. Supports multiple languages, eg. 'eng+deu'. The desired languages must be installed"`
+	TesseractPath    string   `default:"tesseract" help:"Absolute path to the tesseract executable"`
+	TessDataDir      string   `help:"Absolute path to the tesseract data folder"`
 }
 
-func logOutput(annots []*pdfutils.Annotation) {
-	jsonAnnots, err := json.Marshal(annots)
+var outputFile *os.File
+
+func writeOutput(output string) {
+	if args.Output == "" {
+		oLog := log.New(os.Stdout, "", 0)
+		oLog.Print(output)
+		return
+	}
+
+	if !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+
+	if !args.Watch {
+		endIfErr(writeFileAtomic(args.Output, []byte(output)))
+		return
+	}
+
+	if outputFile == nil {
+		var err error
+		outputFile, err = os.OpenFile(args.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		endIfErr(err)
+	}
+
+	oLog := log.New(outputFile, "", 0)
+	oLog.Print(output)
+}
+
+func logOutput(output interface{}) {
+	jsonOutput, err := json.Marshal(output)
 
 	endIfErr(err)
 
-	oLog := log.New(os.Stdout, "", 0)
-	oLog.Println(string(jsonAnnots))
+	writeOutput(string(jsonOutput))
 }
 
 func endIfErr(e error) {
@@ -59,355 +125,159 @@ func endIfErr(e error) {
 	}
 }
 
-func main() {
-	kong.Parse(&args, kong.Vars{
-		"version": version,
-	})
-
-	if args.AttemptOCR {
-		haveTess := pdfutils.CheckForTesseract(args.TesseractPath)
-		if !haveTess {
-			endIfErr(fmt.Errorf("Error: %s not found", args.TesseractPath))
-		}
+func validateArgs() error {
+	if err := validateSchemaVersion(); err != nil {
+		return err
+	}
 
-		valid := pdfutils.ValidateLang(args.TesseractPath, args.OCRLang)
-		if !valid {
-			endIfErr(fmt.Errorf("Error: %s not a valid tesseract language string", args.OCRLang))
+	if args.Pages != "" {
+		if err := pdfutils.ValidatePageRanges(args.Pages); err != nil {
+			return err
 		}
 	}
 
-	skipImages := args.ImageBaseName == "" || args.ImageOutputPath == ""
+	if _, err := newAnnotationFilter(); err != nil {
+		return err
+	}
 
-	f, err := os.Open(args.InputPDF)
-	endIfErr(err)
-	defer f.Close()
+	if _, err := getImageTypes(); err != nil {
+		return err
+	}
 
-	seeker := io.ReadSeeker(f)
+	if _, err := loadBibliography(); err != nil {
+		return err
+	}
 
-	pdfReader, err := model.NewPdfReader(seeker)
-	endIfErr(err)
+	if args.Watch && args.Format != formatJSON {
+		return fmt.Errorf("Error: watch mode only supports --format=json")
+	}
 
-	fitzDoc, err := fitz.New(args.InputPDF)
-	endIfErr(err)
-	defer fitzDoc.Close()
+	if err := validateInlineImages(); err != nil {
+		return err
+	}
 
-	encryption := pdfReader.GetEncryptionMethod()
-	if encryption != "" {
-		success, err := pdfReader.Decrypt([]byte{})
-		endIfErr(err)
+	if args.NativeImages && args.ImageFormat == imageFormatSVG {
+		return fmt.Errorf("Error: --native-images can't be saved as svg")
+	}
+
+	if args.Format == formatSQLite {
+		if args.DB == "" {
+			return fmt.Errorf("Error: --format=sqlite requires --db")
+		}
 
-		if !success {
-			endIfErr(fmt.Errorf("Error: PDF is encrypted, unable to decrypt"))
+		if !checkForSQLite(args.SQLitePath) {
+			return fmt.Errorf("Error: %s not found", args.SQLitePath)
 		}
 	}
 
-	numPages, err := pdfReader.GetNumPages()
-	endIfErr(err)
+	if !args.AttemptOCR {
+		return nil
+	}
 
-	pageLabels, err := pdfReader.GetPageLabels()
-	endIfErr(err)
+	haveTess := pdfutils.CheckForTesseract(args.TesseractPath)
+	if !haveTess {
+		return fmt.Errorf("Error: %s not found", args.TesseractPath)
+	}
 
-	collectedAnnotations := make([][]*pdfutils.Annotation, numPages)
-	g := new(errgroup.Group)
-	mu := sync.Mutex{}
+	valid := pdfutils.ValidateLang(args.TesseractPath, args.OCRLang)
+	if !valid {
+		return fmt.Errorf("Error: %s not a valid tesseract language string", args.OCRLang)
+	}
 
-	pageLabelMap := pdfutils.GetPageLabelMap(numPages, pageLabels)
+	return nil
+}
 
-	for i := 0; i < numPages; i++ {
-		index := i
-		pageLabel, ok := pageLabelMap[i]
+type extractCmd struct {
+	InputPDFs []string `arg:"" name:"input" help:"Paths to input PDFs, directories, or glob patterns. Use - to read a PDF from stdin" type:"path" sep:"none"`
+}
 
-		if !ok {
-			pageLabel = strconv.Itoa(index + 1)
-		}
+func (c *extractCmd) Run() error {
+	if err := validateArgs(); err != nil {
+		return err
+	}
 
-		g.Go(func() error {
-			page, err := pdfReader.GetPage(index + 1)
-			if err != nil {
-				return err
-			}
-
-			if page.MediaBox == nil {
-				mb := pdfutils.GetMediaBox(page)
-
-				if mb == nil {
-					return nil
-				}
-
-				page.MediaBox = mb
-			}
-
-			if page.Rotate == nil {
-				var zero int64 = 0
-				page.Rotate = &zero
-			}
-
-			if page.CropBox == nil {
-				page.CropBox = page.MediaBox
-			}
-
-			mu.Lock()
-			annotations, err := page.GetAnnotations()
-			mu.Unlock()
-			if err != nil {
-				return err
-			}
-
-			if len(annotations) == 0 {
-				return nil
-			}
-
-			haveRectangles := false
-			filtered := []*model.PdfAnnotation{}
-
-			for _, a := range annotations {
-				annotType := pdfutils.GetAnnotationType(a.GetContext())
-
-				if annotType == pdfutils.Unsupported {
-					continue
-				}
-
-				if annotType == pdfutils.Rectangle {
-					haveRectangles = true
-				}
-
-				filtered = append(filtered, a)
-			}
-
-			if len(filtered) == 0 {
-				return nil
-			}
-
-			var pageImg image.Image
-			var ocrImg image.Image
-
-			if haveRectangles && !skipImages {
-				if !args.NoWrite {
-					pageImg, err = fitzDoc.ImageDPI(index, float64(args.ImageDPI))
-					if err != nil {
-						return err
-					}
-				}
-
-				if args.AttemptOCR {
-					ocrImg, err = fitzDoc.ImageDPI(index, 300.0)
-					if err != nil {
-						return err
-					}
-				}
-			}
-
-			annots := processAnnotations(
-				fitzDoc,
-				page,
-				pageLabel,
-				&pageImg,
-				&ocrImg,
-				index,
-				filtered,
-				skipImages,
-			)
-			collectedAnnotations[index] = annots
+	if args.Watch {
+		return watchInputs(c.InputPDFs)
+	}
 
-			return nil
-		})
+	paths, batch, err := expandInputs(c.InputPDFs, args.Recursive)
+	if err != nil {
+		return err
 	}
 
-	err = g.Wait()
-	endIfErr(err)
+	if batch {
+		results := processBatch(paths)
 
-	filtered := []*pdfutils.Annotation{}
+		if args.Format != formatJSON {
+			return writeDocuments(results)
+		}
 
-	for _, annots := range collectedAnnotations {
-		if annots != nil && len(annots) > 0 {
-			filtered = append(filtered, annots...)
+		if args.Envelope {
+			logOutput(batchEnvelope{SchemaVersion: schemaVersion, Tool: getToolMetadata(args.ImageOutputPath), Files: results})
+			return nil
 		}
-	}
 
-	logOutput(filtered)
-}
+		logOutput(results)
+		return nil
+	}
 
-func processAnnotations(
-	fitzDoc *fitz.Document,
-	page *model.PdfPage,
-	pageLabel string,
-	pageImg *image.Image,
-	ocrImg *image.Image,
-	pageIndex int,
-	annotations []*model.PdfAnnotation,
-	skipImages bool,
-) []*pdfutils.Annotation {
-	annots := make([]*pdfutils.Annotation, len(annotations))
-	seenIDs := map[string]bool{}
-
-	ext, err := extractor.New(page)
-	endIfErr(err)
+	checksum := ""
 
-	txt, _, _, err := ext.ExtractPageText()
-	endIfErr(err)
+	if args.CacheDir != "" || args.Envelope || args.Format == formatSQLite {
+		checksum, err = getFileChecksum(paths[0])
+		if err != nil {
+			return err
+		}
+	}
 
-	text := txt.Text()
-	marks := txt.Marks().Elements()
-	markRects := []r2.Rect{}
+	workers.acquire()
+	annots, meta, err := extractAnnotations(paths[0], checksum, args.ImageOutputPath)
+	workers.release()
+	if err != nil {
+		return err
+	}
 
-	for _, mark := range marks {
-		markRects = append(markRects, pdfutils.GetMarkRect(mark))
+	if args.Format != formatJSON {
+		return writeDocuments([]*fileResult{{
+			Path:        paths[0],
+			Checksum:    checksum,
+			Annotations: annots,
+			meta:        meta,
+		}})
 	}
 
-	g := new(errgroup.Group)
-	mu := sync.Mutex{}
+	if args.Envelope {
+		logOutput(envelope{
+			SchemaVersion: schemaVersion,
+			Tool:          getToolMetadata(args.ImageOutputPath),
+			Document:      meta,
+			Annotations:   annots,
+		})
+		return nil
+	}
 
-	for index, annotation := range annotations {
-		annotation := annotation
-		index := index
+	logOutput(annots)
 
-		if annotation == nil {
-			continue
-		}
+	return nil
+}
 
-		g.Go(func() error {
-			annotType := pdfutils.GetAnnotationType(annotation.GetContext())
-			if annotType == pdfutils.Unsupported {
-				return nil
-			}
-
-			date := pdfutils.GetAnnotationDate(annotation)
-			if date != nil && date.Before(args.IgnoreBefore) {
-				return nil
-			}
-
-			x, y := pdfutils.GetCoordinates(annotation)
-
-			mu.Lock()
-			id := pdfutils.GetAnnotationID(seenIDs, pageIndex, x, y, annotType)
-			mu.Unlock()
-
-			if !skipImages && annotType == pdfutils.Rectangle {
-				imgAnnot, err := pdfutils.HandleImageAnnot(pdfutils.ImageAnnotArgs{
-					Page:            page,
-					PageImg:         pageImg,
-					PageIndex:       pageIndex,
-					OCRImg:          ocrImg,
-					AttemptOCR:      args.AttemptOCR,
-					Annotation:      annotation,
-					X:               x,
-					Y:               y,
-					ID:              id,
-					Write:           !args.NoWrite,
-					ImageOutputPath: args.ImageOutputPath,
-					ImageBaseName:   args.ImageBaseName,
-					ImageFormat:     args.ImageFormat,
-					ImageQuality:    args.ImageQuality,
-					TessPath:        args.TesseractPath,
-					TessLang:        args.OCRLang,
-					TessDataDir:     args.TessDataDir,
-				})
-
-				if err != nil {
-					return err
-				}
-
-				annots[index] = imgAnnot
-				return nil
-			}
-
-			str := ""
-			fallbackStr := ""
-			offset := -1
-			top := 0
-
-			if annotType != pdfutils.Text {
-				annoRects := pdfutils.GetAnnotationRects(page, annotation)
-
-				if annoRects == nil {
-					return nil
-				}
-
-				for _, anno := range annoRects {
-					if !anno.IsValid() || anno.IsEmpty() {
-						return nil
-					}
-
-					_, o := pdfutils.GetBoundsFromAnnotMarks(anno, markRects)
-
-					if offset == -1 {
-						offset = o
-						top = int(math.Max(page.MediaBox.Height()-anno.Y.Hi, 0.0))
-					}
-
-					annotText, err := pdfutils.GetTextByAnnotBounds(fitzDoc, pageIndex, page, anno)
-					endIfErr(err)
-
-					if str == "" {
-						str = annotText
-					} else if strings.HasSuffix(str, " ") {
-						str += annotText
-					} else {
-						str += " " + annotText
-					}
-
-					fallback := pdfutils.GetFallbackText(text, anno, markRects, marks)
-
-					if fallbackStr == "" {
-						fallbackStr = fallback
-					} else if strings.HasSuffix(fallbackStr, " ") {
-						fallbackStr += fallback
-					} else {
-						fallbackStr += " " + fallback
-					}
-				}
-			} else {
-				offset = pdfutils.GetClosestMark(x, y, markRects)
-				top = int(math.Max(page.MediaBox.Height()-y, 0.0))
-			}
-
-			comment := ""
-
-			if annotation.Contents != nil {
-				comment = pdfutils.RemoveNul(annotation.Contents.String())
-			}
-
-			annotatedText := str
-
-			if pdfutils.ShouldUseFallback(str, fallbackStr) {
-				annotatedText = fallbackStr
-			}
-
-			builtAnnot := &pdfutils.Annotation{
-				AnnotatedText: pdfutils.DeHyphen(pdfutils.CondenseSpaces(pdfutils.ExpandLigatures(annotatedText))),
-				Color:         pdfutils.GetAnnotationColor(annotation),
-				ColorCategory: pdfutils.GetAnnotationColorCategory(annotation),
-				Comment:       comment,
-				Type:          annotType,
-				Page:          pageIndex + 1,
-				PageLabel:     pageLabel,
-				X:             x,
-				Y:             y,
-				ID:            id,
-				SortIndex:     pdfutils.GetAnnotationSortKey(pageIndex, offset, top),
-			}
-
-			if date != nil {
-				builtAnnot.Date = date.Format(time.RFC3339)
-			}
-
-			annots[index] = builtAnnot
-			return nil
-		})
-	}
+func main() {
+	config := &configResolver{}
 
-	err = g.Wait()
-	endIfErr(err)
+	parser := kong.Must(&args, kong.Vars{
+		"version":       version,
+		"schemaVersion": fmt.Sprint(schemaVersion),
+		"jobs":          fmt.Sprint(runtime.NumCPU()),
+	}, kong.Resolvers(config))
 
-	filtered := []*pdfutils.Annotation{}
+	ctx, err := parser.Parse(os.Args[1:])
 
-	for _, annot := range annots {
-		if annot != nil {
-			filtered = append(filtered, annot)
-		}
-	}
+	// Config errors are reported as is rather than against whichever flag
+	// was being resolved when the config was loaded
+	endIfErr(config.err)
+	parser.FatalIfErrorf(err)
 
-	sort.Sort(pdfutils.BySortIndex(filtered))
+	workers = newWorkerLimit(args.Jobs)
 
-	return filtered
+	endIfErr(ctx.Run())
 }
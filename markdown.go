@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+// Citations use Pandoc's syntax when the document has a citekey
+func getMarkdownCitation(annot *pdfutils.Annotation) string {
+	if annot.Citekey == "" {
+		return fmt.Sprintf("(p. %s)", annot.PageLabel)
+	}
+
+	return fmt.Sprintf("[@%s, p. %s]", annot.Citekey, annot.PageLabel)
+}
+
+func writeMarkdownQuote(w *bytes.Buffer, text string, suffix string) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+
+	for i, line := range lines {
+		w.WriteString("> " + strings.TrimSpace(line))
+
+		if i == len(lines)-1 {
+			w.WriteString(" " + suffix)
+		}
+
+		w.WriteString("\n")
+	}
+}
+
+// Each annotation ends with a block ID, eg. "^highlight-p1x50y689", so it can
+// be linked to or transcluded from other notes.
+func writeMarkdownAnnotation(w *bytes.Buffer, annot *pdfutils.Annotation) {
+	suffix := getMarkdownCitation(annot) + " ^" + annot.ID
+	comment := strings.TrimSpace(annot.Comment)
+
+	switch annot.Type {
+	case pdfutils.Text:
+		w.WriteString(comment + " " + suffix + "\n")
+		return
+	case pdfutils.Image:
+		if uri := getImageDataURI(annot); uri != "" {
+			w.WriteString(fmt.Sprintf("![](%s)\n\n", uri))
+		} else if annot.ImagePath != "" {
+			w.WriteString(fmt.Sprintf("![](%s)\n\n", annot.ImagePath))
+		}
+
+		if annot.OCRText != "" {
+			writeMarkdownQuote(w, annot.OCRText, suffix)
+		} else {
+			w.WriteString(suffix + "\n")
+		}
+	case pdfutils.Rectangle:
+		w.WriteString(suffix + "\n")
+	default:
+		writeMarkdownQuote(w, annot.AnnotatedText, suffix)
+	}
+
+	if comment != "" {
+		w.WriteString("\n" + comment + "\n")
+	}
+}
+
+func writeMarkdown(w *bytes.Buffer, docs []*fileResult) {
+	for i, doc := range docs {
+		if i > 0 {
+			w.WriteString("\n")
+		}
+
+		w.WriteString("# " + getDocumentTitle(doc) + "\n")
+
+		var prev *pdfutils.Annotation
+
+		for _, annot := range doc.Annotations {
+			for level, heading := range getGroupHeadings(prev, annot) {
+				if heading != "" {
+					w.WriteString("\n" + strings.Repeat("#", level+2) + " " + heading + "\n")
+				}
+			}
+
+			w.WriteString("\n")
+			writeMarkdownAnnotation(w, annot)
+
+			prev = annot
+		}
+	}
+}
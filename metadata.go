@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+	"github.com/mgmeyers/unipdf/v3/extractor"
+	"golang.org/x/sync/errgroup"
+)
+
+type documentMetadata struct {
+	Path        string                   `json:"path"`
+	SHA256      string                   `json:"sha256,omitempty"`
+	Fingerprint string                   `json:"fingerprint"`
+	PageCount   int                      `json:"pageCount"`
+	PdfVersion  string                   `json:"pdfVersion"`
+	Info        map[string]string        `json:"info"`
+	XMP         string                   `json:"xmp,omitempty"`
+	PageLabels  []string                 `json:"pageLabels,omitempty"`
+	Outline     []*pdfutils.OutlineEntry `json:"outline,omitempty"`
+
+	// Either "bookmarks" or "inferred"
+	OutlineSource string `json:"outlineSource,omitempty"`
+
+	Identifiers *pdfutils.Identifiers `json:"identifiers,omitempty"`
+	Citekey     string                `json:"citekey,omitempty"`
+
+	// Page images embedded with --image-inline, keyed by page number
+	PageImages map[int]string `json:"pageImages,omitempty"`
+
+	// What text output needs from each annotated page, keyed by page number.
+	// It is kept from extraction so that the PDF isn't opened again.
+	pages map[int]*pageData
+}
+
+// Read from an annotated page during extraction, and cached with the page
+type pageData struct {
+	// The text of the page, to find the sentence around highlights
+	Text string `json:"text,omitempty"`
+
+	// A data URI of a thumbnail of the page for HTML output, and the areas of
+	// each annotation on it by annotation id, as the left, top, width, and
+	// height in percent of the page's size
+	Thumbnail string                 `json:"thumbnail,omitempty"`
+	Overlays  map[string][][]float64 `json:"overlays,omitempty"`
+}
+
+// Anki and Hypothesis output quote the sentence around each highlight
+func needsPageText() bool {
+	return args.Format == formatAnki || args.Format == formatHypothesis
+}
+
+// HTML output shows a thumbnail of each annotated page, which is rendered
+// while the page is extracted
+func needsThumbnails() bool {
+	return args.Format == formatHTML
+}
+
+// Identifiers are only searched for on the first pages, where papers and
+// books print them
+const identifierPages = 3
+
+// Searching for identifiers reads the text of the first pages, so it is only
+// done when they are looked up in the bibliography or output
+func needsIdentifiers() bool {
+	switch args.Format {
+	case formatSQLite, formatAnki, formatReadwise, formatHypothesis:
+		return true
+	}
+
+	return args.Bib != "" || args.Envelope
+}
+
+type toolMetadata struct {
+	Name    string            `json:"name"`
+	Version string            `json:"version"`
+	Options extractionOptions `json:"options"`
+}
+
+type envelope struct {
+	SchemaVersion int                    `json:"schemaVersion"`
+	Tool          toolMetadata           `json:"tool"`
+	Document      *documentMetadata      `json:"document"`
+	Annotations   []*pdfutils.Annotation `json:"annotations"`
+}
+
+type batchEnvelope struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	Tool          toolMetadata  `json:"tool"`
+	Files         []*fileResult `json:"files"`
+}
+
+// Keys of the info output, and the entries of the information dictionary they
+// are read from
+var infoEntries = map[string]string{
+	"title":        "Title",
+	"author":       "Author",
+	"subject":      "Subject",
+	"keywords":     "Keywords",
+	"creator":      "Creator",
+	"producer":     "Producer",
+	"creationDate": "CreationDate",
+	"modDate":      "ModDate",
+}
+
+var pdfDateKeys = map[string]bool{
+	"creationDate": true,
+	"modDate":      true,
+}
+
+// Reads the document's metadata. The caller must hold the document's lock.
+// Path and checksum are left to the caller, since the same document may be
+// read from different paths.
+func getDocumentMetadata(doc *pdfDocument, numPages int, pageLabelMap map[int]string, hasPageLabels bool) (*documentMetadata, error) {
+	fingerprint, err := getDocumentFingerprint(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &documentMetadata{
+		Fingerprint: fingerprint,
+		PageCount:   numPages,
+		PdfVersion:  doc.reader.PdfVersion().String(),
+		Info:        map[string]string{},
+		XMP:         pdfutils.GetXMPMetadata(doc.reader),
+	}
+
+	// Entries are read directly rather than through fitz, which cuts values
+	// off at 255 bytes
+	for key, entry := range infoEntries {
+		value := strings.TrimSpace(pdfutils.GetInfoEntry(doc.reader, entry))
+		if value == "" {
+			continue
+		}
+
+		if pdfDateKeys[key] {
+			value = pdfutils.FormatPdfDate(value)
+		}
+
+		meta.Info[key] = value
+	}
+
+	if needsIdentifiers() {
+		// The document's own metadata is preferred over its text, and earlier
+		// pages over later ones
+		texts := []string{
+			meta.XMP,
+			pdfutils.GetInfoEntry(doc.reader, "doi"),
+			pdfutils.GetInfoEntry(doc.reader, "DOI"),
+			meta.Info["subject"],
+			meta.Info["keywords"],
+		}
+
+		for i := 0; i < numPages && i < identifierPages; i++ {
+			text, err := getPageText(doc, i)
+			if err != nil {
+				return nil, err
+			}
+
+			text, isReferences := pdfutils.TrimReferences(text)
+			texts = append(texts, text)
+
+			if isReferences {
+				break
+			}
+		}
+
+		meta.Identifiers = pdfutils.FindIdentifiers(texts...)
+	}
+	meta.Outline = getDocumentOutline(doc)
+
+	if len(meta.Outline) > 0 {
+		meta.OutlineSource = "bookmarks"
+	}
+
+	if hasPageLabels {
+		meta.PageLabels = make([]string, numPages)
+
+		for i := range meta.PageLabels {
+			meta.PageLabels[i] = pdfutils.GetPageLabel(pageLabelMap, i)
+		}
+	}
+
+	return meta, nil
+}
+
+// Reads the outline through fitz, which resolves named destinations to a page
+// and position.
+func getDocumentOutline(doc *pdfDocument) []*pdfutils.OutlineEntry {
+	toc, err := doc.fitzDoc.ToC()
+	if err != nil {
+		return nil
+	}
+
+	outline := []*pdfutils.OutlineEntry{}
+
+	for _, item := range toc {
+		if item.Page < 0 {
+			continue
+		}
+
+		page, err := doc.getPage(item.Page)
+		if err != nil || page == nil {
+			continue
+		}
+
+		outline = append(outline, &pdfutils.OutlineEntry{
+			Title: strings.TrimSpace(pdfutils.RemoveNul(item.Title)),
+			Level: item.Level,
+			Page:  item.Page + 1,
+			Top:   pdfutils.NormalizeOutlineTop(item.Top, page.CropBox.Ury),
+		})
+	}
+
+	return outline
+}
+
+// Identifies the document the way PDF.js does: by its file ID, or the MD5 of
+// its first 1024 bytes when it has none. Unlike the checksum, the file ID
+// stays the same when the PDF is edited.
+func getDocumentFingerprint(doc *pdfDocument) (string, error) {
+	if id := pdfutils.GetFileID(doc.reader); id != "" {
+		return id, nil
+	}
+
+	head, err := doc.readHead(1024)
+	if err != nil {
+		return "", err
+	}
+
+	sum := md5.Sum(head)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Returns the text extracted from an annotated page, or an empty string when
+// it wasn't kept
+func getDocumentPageText(doc *fileResult, page int) string {
+	if doc.meta == nil || doc.meta.pages[page] == nil {
+		return ""
+	}
+
+	return doc.meta.pages[page].Text
+}
+
+func getPageText(doc *pdfDocument, pageIndex int) (string, error) {
+	page, err := doc.getPage(pageIndex)
+	if err != nil || page == nil {
+		return "", err
+	}
+
+	ext, err := extractor.New(page)
+	if err != nil {
+		return "", err
+	}
+
+	txt, _, _, err := ext.ExtractPageText()
+	if err != nil {
+		return "", err
+	}
+
+	return txt.Text(), nil
+}
+
+// Sets the fields of the annotations that depend on the whole document. This
+// runs after extraction, since these are not cached per page and the
+// bibliography may have changed since the document was cached.
+func applyDocumentMetadata(annots []*pdfutils.Annotation, meta *documentMetadata) error {
+	pdfutils.AssignSections(annots, meta.Outline)
+
+	for _, annot := range annots {
+		if annot.PageImageData == "" {
+			continue
+		}
+
+		if meta.PageImages == nil {
+			meta.PageImages = map[int]string{}
+		}
+
+		meta.PageImages[annot.Page] = annot.PageImageData
+		annot.PageImageData = ""
+	}
+
+	if args.Citekey != "" {
+		meta.Citekey = args.Citekey
+	} else {
+		bib, err := loadBibliography()
+		if err != nil || bib == nil {
+			return err
+		}
+
+		meta.Citekey = bib.lookup(meta.Identifiers)
+	}
+
+	for _, annot := range annots {
+		annot.Citekey = meta.Citekey
+	}
+
+	return nil
+}
+
+// Infers headings from the font sizes and weights of the text on the pages
+// up to the last annotated one, since headings are only needed to find the
+// sections of annotations. Pages whose text was already extracted along with
+// their annotations aren't read again. The caller must hold the document's
+// lock.
+func inferDocumentOutline(
+	doc *pdfDocument,
+	lastPage int,
+	pageLines [][]*pdfutils.TextLine,
+	scannedPages []bool,
+	cache *extractionCache,
+) ([]*pdfutils.OutlineEntry, error) {
+	key := ""
+
+	// The outline only depends on the content of the pages, so it is reused
+	// when only the annotations changed
+	if cache != nil {
+		fingerprints := []string{}
+
+		for i := 0; i <= lastPage; i++ {
+			page, err := doc.getPage(i)
+			if err != nil {
+				return nil, err
+			}
+
+			if page != nil {
+				fingerprints = append(fingerprints, pdfutils.GetPageContentFingerprint(page))
+			}
+		}
+
+		key = pdfutils.GetOutlineKey(fingerprints)
+
+		if outline, ok := cache.getOutline(key); ok {
+			return outline, nil
+		}
+	}
+
+	g := new(errgroup.Group)
+
+	for i := 0; i <= lastPage; i++ {
+		if scannedPages[i] {
+			continue
+		}
+
+		index := i
+
+		workers.Go(g, func() error {
+			page, err := doc.getPage(index)
+			if err != nil || page == nil {
+				return err
+			}
+
+			ext, err := extractor.New(page)
+			if err != nil {
+				return err
+			}
+
+			txt, _, _, err := ext.ExtractPageText()
+			if err != nil {
+				return err
+			}
+
+			pageLines[index] = pdfutils.GetTextLines(txt.Marks().Elements(), index+1)
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	lines := []*pdfutils.TextLine{}
+	for _, l := range pageLines[:lastPage+1] {
+		lines = append(lines, l...)
+	}
+
+	outline := pdfutils.InferOutline(lines)
+
+	if cache != nil {
+		if err := cache.putOutline(key, outline); err != nil {
+			return nil, err
+		}
+	}
+
+	return outline, nil
+}
+
+func getToolMetadata(imageOutputPath string) toolMetadata {
+	return toolMetadata{
+		Name:    "pdfannots2json",
+		Version: version,
+		Options: getExtractionOptions(shouldSkipImages(imageOutputPath)),
+	}
+}
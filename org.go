@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+const orgHeadingLength = 60
+
+// Lines that would otherwise start a heading or a keyword are escaped with a
+// comma, as Org does itself
+func escapeOrg(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+
+		if strings.HasPrefix(line, "*") || strings.HasPrefix(line, "#+") || strings.HasPrefix(line, ",*") || strings.HasPrefix(line, ",#+") {
+			line = "," + line
+		}
+
+		lines[i] = line
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func getOrgHeading(annot *pdfutils.Annotation) string {
+	text := annot.AnnotatedText
+	if text == "" {
+		text = annot.Comment
+	}
+
+	if text == "" {
+		text = annot.OCRText
+	}
+
+	text = strings.Join(strings.Fields(text), " ")
+
+	if runes := []rune(text); len(runes) > orgHeadingLength {
+		text = strings.TrimSpace(string(runes[:orgHeadingLength])) + "…"
+	}
+
+	title := strings.ToUpper(annot.Type[:1]) + annot.Type[1:]
+	if text == "" {
+		return title
+	}
+
+	return title + ": " + text
+}
+
+// Dates are written as inactive timestamps, so they show up in the agenda
+// without scheduling anything
+func getOrgDate(date string) string {
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return date
+	}
+
+	return t.Local().Format("[2006-01-02 Mon 15:04]")
+}
+
+func writeOrgAnnotation(w *bytes.Buffer, annot *pdfutils.Annotation, level int) {
+	w.WriteString(strings.Repeat("*", level) + " " + getOrgHeading(annot) + "\n")
+
+	props := [][2]string{
+		{"ID", annot.ID},
+		{"PAGE", fmt.Sprint(annot.Page)},
+		{"PAGE_LABEL", annot.PageLabel},
+		{"COLOR", annot.Color},
+		{"DATE", getOrgDate(annot.Date)},
+		{"IMAGE_PATH", annot.ImagePath},
+		{"CITEKEY", annot.Citekey},
+	}
+
+	w.WriteString(":PROPERTIES:\n")
+
+	for _, p := range props {
+		if p[1] != "" {
+			w.WriteString(fmt.Sprintf(":%s: %s\n", p[0], p[1]))
+		}
+	}
+
+	w.WriteString(":END:\n")
+
+	// Org can't display data URIs itself, so embedded images are only shown
+	// when exported to HTML
+	if uri := getImageDataURI(annot); uri != "" {
+		w.WriteString(fmt.Sprintf("#+html: <img src=\"%s\">\n", uri))
+	} else if annot.ImagePath != "" {
+		w.WriteString(fmt.Sprintf("[[file:%s]]\n", annot.ImagePath))
+	}
+
+	quote := annot.AnnotatedText
+	if annot.Type == pdfutils.Image {
+		quote = annot.OCRText
+	}
+
+	if strings.TrimSpace(quote) != "" {
+		w.WriteString("#+begin_quote\n" + escapeOrg(quote) + "\n#+end_quote\n")
+	}
+
+	if strings.TrimSpace(annot.Comment) != "" {
+		w.WriteString(escapeOrg(annot.Comment) + "\n")
+	}
+}
+
+func writeOrg(w *bytes.Buffer, docs []*fileResult) {
+	for i, doc := range docs {
+		if i > 0 {
+			w.WriteString("\n")
+		}
+
+		w.WriteString("* " + getDocumentTitle(doc) + "\n")
+
+		var prev *pdfutils.Annotation
+		level := 2
+
+		for _, annot := range doc.Annotations {
+			headings := getGroupHeadings(prev, annot)
+
+			for l, heading := range headings {
+				if heading != "" {
+					w.WriteString(strings.Repeat("*", l+2) + " " + heading + "\n")
+				}
+			}
+
+			if headings != nil {
+				level = len(headings) + 2
+			}
+
+			writeOrgAnnotation(w, annot, level)
+
+			prev = annot
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+const (
+	formatJSON       = "json"
+	formatMarkdown   = "markdown"
+	formatOrg        = "org"
+	formatHTML       = "html"
+	formatSQLite     = "sqlite"
+	formatAnki       = "anki"
+	formatReadwise   = "readwise-csv"
+	formatHypothesis = "hypothesis"
+)
+
+func getDocumentTitle(doc *fileResult) string {
+	if doc.meta != nil && doc.meta.Info["title"] != "" {
+		return doc.meta.Info["title"]
+	}
+
+	if doc.Path == stdinPath {
+		return "stdin"
+	}
+
+	return strings.TrimSuffix(filepath.Base(doc.Path), filepath.Ext(doc.Path))
+}
+
+// Links to the document by its DOI or arXiv ID, when it has one
+func getDocumentURL(doc *fileResult) string {
+	if doc.meta == nil || doc.meta.Identifiers == nil {
+		return ""
+	}
+
+	if doc.meta.Identifiers.DOI != "" {
+		return "https://doi.org/" + doc.meta.Identifiers.DOI
+	}
+
+	if doc.meta.Identifiers.ArXiv != "" {
+		return "https://arxiv.org/abs/" + doc.meta.Identifiers.ArXiv
+	}
+
+	return ""
+}
+
+// Returns the image embedded with --image-inline as a data URI, whether or not
+// it was embedded as one
+func getImageDataURI(annot *pdfutils.Annotation) string {
+	if annot.ImageData == "" || strings.HasPrefix(annot.ImageData, "data:") {
+		return annot.ImageData
+	}
+
+	return "data:" + pdfutils.GetImageMimeType(args.ImageFormat) + ";base64," + annot.ImageData
+}
+
+// Annotations are grouped by section when the document has an outline, and
+// by page otherwise. Returns the headings to write before the annotation,
+// relative to the level of the document's own heading.
+func getGroupHeadings(prev *pdfutils.Annotation, annot *pdfutils.Annotation) []string {
+	if len(annot.Section) == 0 {
+		if prev != nil && prev.Page == annot.Page && len(prev.Section) == 0 {
+			return nil
+		}
+
+		return []string{"Page " + annot.PageLabel}
+	}
+
+	// Only the part of the chain that differs from the previous annotation
+	// starts new headings
+	same := 0
+
+	if prev != nil {
+		for same < len(prev.Section) && same < len(annot.Section) && prev.Section[same] == annot.Section[same] {
+			same++
+		}
+
+		if same == len(annot.Section) && len(prev.Section) == len(annot.Section) {
+			return nil
+		}
+	}
+
+	headings := make([]string, len(annot.Section))
+	for i := same; i < len(annot.Section); i++ {
+		headings[i] = annot.Section[i]
+	}
+
+	return headings
+}
+
+// Writes the documents in a text format. Files that failed are reported on
+// stderr rather than in the output.
+func writeDocuments(docs []*fileResult) error {
+	eLog := log.New(os.Stderr, "", 0)
+	out := &bytes.Buffer{}
+
+	ok := []*fileResult{}
+	for _, doc := range docs {
+		if len(doc.Errors) > 0 {
+			eLog.Println(fmt.Sprintf("%s: %s", doc.Path, strings.Join(doc.Errors, "; ")))
+			continue
+		}
+
+		ok = append(ok, doc)
+	}
+
+	switch args.Format {
+	case formatSQLite:
+		return writeSQLite(ok)
+	case formatMarkdown:
+		writeMarkdown(out, ok)
+	case formatOrg:
+		writeOrg(out, ok)
+	case formatHTML:
+		if err := writeHTML(out, ok); err != nil {
+			return err
+		}
+	case formatAnki:
+		if err := writeAnki(out, ok); err != nil {
+			return err
+		}
+	case formatReadwise:
+		if err := writeReadwiseCSV(out, ok); err != nil {
+			return err
+		}
+	case formatHypothesis:
+		if err := writeHypothesis(out, ok); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Error: unsupported format %s", args.Format)
+	}
+
+	writeOutput(out.String())
+
+	return nil
+}
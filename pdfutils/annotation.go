@@ -11,20 +11,33 @@ const (
 )
 
 type Annotation struct {
-	AnnotatedText string  `json:"annotatedText,omitempty"`
-	Color         string  `json:"color,omitempty"`
-	ColorCategory string  `json:"colorCategory,omitempty"`
-	Comment       string  `json:"comment,omitempty"`
-	Date          string  `json:"date,omitempty"`
-	ID            string  `json:"id"`
-	ImagePath     string  `json:"imagePath,omitempty"`
-	OCRText       string  `json:"ocrText,omitempty"`
-	Page          int     `json:"page"`
-	PageLabel     string  `json:"pageLabel"`
-	Type          string  `json:"type"`
-	X             float64 `json:"x"`
-	Y             float64 `json:"y"`
-	SortIndex     string  `json:"-"`
+	AnnotatedText string      `json:"annotatedText,omitempty"`
+	Author        string      `json:"author,omitempty"`
+	Citekey       string      `json:"citekey,omitempty"`
+	Color         string      `json:"color,omitempty"`
+	ColorCategory string      `json:"colorCategory,omitempty"`
+	Comment       string      `json:"comment,omitempty"`
+	Date          string      `json:"date,omitempty"`
+	ID            string      `json:"id"`
+	ImagePath     string      `json:"imagePath,omitempty"`
+	ImageData     string      `json:"imageData,omitempty"`
+	Name          string      `json:"name,omitempty"`
+	OCRText       string      `json:"ocrText,omitempty"`
+	Page          int         `json:"page"`
+	PageLabel     string      `json:"pageLabel"`
+	PageImagePath string      `json:"pageImagePath,omitempty"`
+	Quads         [][]float64 `json:"quads,omitempty"`
+	Rect          []float64   `json:"rect,omitempty"`
+	Section       []string    `json:"section,omitempty"`
+	Type          string      `json:"type"`
+	X             float64     `json:"x"`
+	Y             float64     `json:"y"`
+	SortIndex     string      `json:"-"`
+
+	// Set on the first annotation of a page when its page image is embedded,
+	// and moved to the document's metadata before output. Stored here so
+	// that it is cached with the page.
+	PageImageData string `json:"pageImageData,omitempty"`
 }
 
 type BySortIndex []*Annotation
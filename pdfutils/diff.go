@@ -0,0 +1,287 @@
+package pdfutils
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+type ModifiedAnnotation struct {
+	Old     *Annotation `json:"old"`
+	New     *Annotation `json:"new"`
+	Changes []string    `json:"changes"`
+}
+
+type AnnotationDiff struct {
+	Added    []*Annotation         `json:"added"`
+	Removed  []*Annotation         `json:"removed"`
+	Modified []*ModifiedAnnotation `json:"modified"`
+}
+
+// Annotations are only fuzzy matched when their score reaches this threshold.
+const diffMatchThresh = 0.5
+
+// Rectangle annotations are exported as images when images are enabled, so
+// both types are treated as the same kind of annotation.
+func normalizeDiffType(t string) string {
+	if t == Image {
+		return Rectangle
+	}
+
+	return t
+}
+
+func getRectOverlap(a []float64, b []float64) float64 {
+	if len(a) < 4 || len(b) < 4 {
+		return 0
+	}
+
+	w := math.Min(a[2], b[2]) - math.Max(a[0], b[0])
+	h := math.Min(a[3], b[3]) - math.Max(a[1], b[1])
+
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+
+	intersect := w * h
+	union := (a[2]-a[0])*(a[3]-a[1]) + (b[2]-b[0])*(b[3]-b[1]) - intersect
+
+	if union <= 0 {
+		return 0
+	}
+
+	return intersect / union
+}
+
+// Highlights spanning several lines are compared by their quads, since
+// their rects also cover the space beside the shorter lines.
+func getQuadOverlap(a [][]float64, b [][]float64) float64 {
+	area := func(r []float64) float64 {
+		if len(r) < 4 {
+			return 0
+		}
+
+		return (r[2] - r[0]) * (r[3] - r[1])
+	}
+
+	intersect := 0.0
+	total := 0.0
+
+	for _, qa := range a {
+		total += area(qa)
+
+		for _, qb := range b {
+			if len(qa) < 4 || len(qb) < 4 {
+				continue
+			}
+
+			w := math.Min(qa[2], qb[2]) - math.Max(qa[0], qb[0])
+			h := math.Min(qa[3], qb[3]) - math.Max(qa[1], qb[1])
+
+			if w > 0 && h > 0 {
+				intersect += w * h
+			}
+		}
+	}
+
+	for _, qb := range b {
+		total += area(qb)
+	}
+
+	union := total - intersect
+	if union <= 0 {
+		return 0
+	}
+
+	return intersect / union
+}
+
+func getTextSimilarity(a string, b string) float64 {
+	aWords := strings.Fields(strings.ToLower(a))
+	bWords := strings.Fields(strings.ToLower(b))
+
+	if len(aWords) == 0 && len(bWords) == 0 {
+		return 0
+	}
+
+	counts := map[string]int{}
+	for _, w := range aWords {
+		counts[w]++
+	}
+
+	shared := 0
+	for _, w := range bWords {
+		if counts[w] > 0 {
+			counts[w]--
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(aWords)+len(bWords)-shared)
+}
+
+func getPositionSimilarity(a *Annotation, b *Annotation) float64 {
+	if len(a.Quads) > 0 && len(b.Quads) > 0 {
+		return getQuadOverlap(a.Quads, b.Quads)
+	}
+
+	if len(a.Rect) == 4 && len(b.Rect) == 4 {
+		return getRectOverlap(a.Rect, b.Rect)
+	}
+
+	// Older exports don't include a rect, so fall back to the distance
+	// between the annotations' origins
+	return math.Max(0, 1-distanceBetween(a.X, a.Y, b.X, b.Y)/50)
+}
+
+func getMatchScore(a *Annotation, b *Annotation) float64 {
+	if a.Page != b.Page || normalizeDiffType(a.Type) != normalizeDiffType(b.Type) {
+		return 0
+	}
+
+	position := getPositionSimilarity(a, b)
+	text := a.AnnotatedText + " " + a.OCRText
+	otherText := b.AnnotatedText + " " + b.OCRText
+
+	if strings.TrimSpace(text) == "" && strings.TrimSpace(otherText) == "" {
+		return position
+	}
+
+	return (position + getTextSimilarity(text, otherText)) / 2
+}
+
+func rectsDiffer(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return true
+	}
+
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 0.5 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getChanges(a *Annotation, b *Annotation) []string {
+	changes := []string{}
+
+	if a.Comment != b.Comment {
+		changes = append(changes, "comment")
+	}
+
+	if a.Color != b.Color {
+		changes = append(changes, "color")
+	}
+
+	if a.AnnotatedText != b.AnnotatedText {
+		changes = append(changes, "text")
+	}
+
+	if a.Page != b.Page ||
+		math.Abs(a.X-b.X) > 0.5 ||
+		math.Abs(a.Y-b.Y) > 0.5 ||
+		(len(a.Rect) > 0 && len(b.Rect) > 0 && rectsDiffer(a.Rect, b.Rect)) {
+		changes = append(changes, "position")
+	}
+
+	return changes
+}
+
+// DiffAnnotations matches annotations by their name (NM) when both sides have
+// one, and otherwise by page, the overlap of their quads or rects, and
+// annotated text.
+func DiffAnnotations(oldAnnots []*Annotation, newAnnots []*Annotation) *AnnotationDiff {
+	diff := &AnnotationDiff{
+		Added:    []*Annotation{},
+		Removed:  []*Annotation{},
+		Modified: []*ModifiedAnnotation{},
+	}
+
+	matches := map[int]int{}
+	matchedNew := map[int]bool{}
+	newByName := map[string]int{}
+
+	for j, b := range newAnnots {
+		if b.Name != "" {
+			newByName[b.Name] = j
+		}
+	}
+
+	for i, a := range oldAnnots {
+		if a.Name == "" {
+			continue
+		}
+
+		if j, ok := newByName[a.Name]; ok && !matchedNew[j] {
+			matches[i] = j
+			matchedNew[j] = true
+		}
+	}
+
+	type candidate struct {
+		i, j  int
+		score float64
+	}
+
+	candidates := []candidate{}
+
+	for i, a := range oldAnnots {
+		if _, ok := matches[i]; ok {
+			continue
+		}
+
+		for j, b := range newAnnots {
+			if matchedNew[j] {
+				continue
+			}
+
+			// Annotations that both have a name are only matched by name
+			if a.Name != "" && b.Name != "" {
+				continue
+			}
+
+			if score := getMatchScore(a, b); score >= diffMatchThresh {
+				candidates = append(candidates, candidate{i, j, score})
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(x, y int) bool {
+		return candidates[x].score > candidates[y].score
+	})
+
+	for _, c := range candidates {
+		if _, ok := matches[c.i]; ok || matchedNew[c.j] {
+			continue
+		}
+
+		matches[c.i] = c.j
+		matchedNew[c.j] = true
+	}
+
+	for i, a := range oldAnnots {
+		j, ok := matches[i]
+		if !ok {
+			diff.Removed = append(diff.Removed, a)
+			continue
+		}
+
+		if changes := getChanges(a, newAnnots[j]); len(changes) > 0 {
+			diff.Modified = append(diff.Modified, &ModifiedAnnotation{
+				Old:     a,
+				New:     newAnnots[j],
+				Changes: changes,
+			})
+		}
+	}
+
+	for j, b := range newAnnots {
+		if !matchedNew[j] {
+			diff.Added = append(diff.Added, b)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,120 @@
+package pdfutils
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func highlight(name string, page int, text string, comment string, quads ...[]float64) *Annotation {
+	rect := append([]float64{}, quads[0]...)
+	for _, q := range quads[1:] {
+		rect[0] = math.Min(rect[0], q[0])
+		rect[1] = math.Min(rect[1], q[1])
+		rect[2] = math.Max(rect[2], q[2])
+		rect[3] = math.Max(rect[3], q[3])
+	}
+
+	return &Annotation{
+		Type:          Highlight,
+		Name:          name,
+		Page:          page,
+		AnnotatedText: text,
+		Comment:       comment,
+		Quads:         quads,
+		Rect:          rect,
+		X:             rect[0],
+		Y:             rect[1],
+	}
+}
+
+func TestDiffAnnotations(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      []*Annotation
+		new      []*Annotation
+		added    int
+		removed  int
+		modified [][]string
+	}{
+		{
+			name: "matched",
+			old:  []*Annotation{highlight("", 1, "some text", "", []float64{50, 600, 300, 612})},
+			new:  []*Annotation{highlight("", 1, "some text", "", []float64{50, 600, 300, 612})},
+		},
+		{
+			name:     "matched by name",
+			old:      []*Annotation{highlight("a", 1, "some text", "", []float64{50, 600, 300, 612})},
+			new:      []*Annotation{highlight("a", 1, "other words", "note", []float64{50, 100, 300, 112})},
+			modified: [][]string{{"comment", "text", "position"}},
+		},
+		{
+			name:     "moved",
+			old:      []*Annotation{highlight("", 1, "some text here", "", []float64{50, 600, 300, 612})},
+			new:      []*Annotation{highlight("", 1, "some text here", "", []float64{52, 601, 302, 613})},
+			modified: [][]string{{"position"}},
+		},
+		{
+			name:     "modified",
+			old:      []*Annotation{highlight("", 1, "some text", "", []float64{50, 600, 300, 612})},
+			new:      []*Annotation{highlight("", 1, "some text", "a comment", []float64{50, 600, 300, 612})},
+			modified: [][]string{{"comment"}},
+		},
+		{
+			name:  "added",
+			old:   []*Annotation{},
+			new:   []*Annotation{highlight("", 1, "some text", "", []float64{50, 600, 300, 612})},
+			added: 1,
+		},
+		{
+			name:    "removed",
+			old:     []*Annotation{highlight("", 1, "some text", "", []float64{50, 600, 300, 612})},
+			new:     []*Annotation{},
+			removed: 1,
+		},
+		{
+			name:    "different page",
+			old:     []*Annotation{highlight("", 1, "some text", "", []float64{50, 600, 300, 612})},
+			new:     []*Annotation{highlight("", 2, "some text", "", []float64{50, 600, 300, 612})},
+			added:   1,
+			removed: 1,
+		},
+		{
+			// The rects of these highlights overlap, but their lines don't
+			name: "matched by quads",
+			old: []*Annotation{
+				highlight("", 1, "", "", []float64{50, 600, 500, 612}, []float64{50, 588, 100, 600}),
+			},
+			new: []*Annotation{
+				highlight("", 1, "", "", []float64{450, 600, 500, 612}, []float64{50, 588, 500, 600}),
+			},
+			added:   1,
+			removed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		diff := DiffAnnotations(tt.old, tt.new)
+
+		if len(diff.Added) != tt.added {
+			t.Errorf("%s: added %d annotations, want %d", tt.name, len(diff.Added), tt.added)
+		}
+
+		if len(diff.Removed) != tt.removed {
+			t.Errorf("%s: removed %d annotations, want %d", tt.name, len(diff.Removed), tt.removed)
+		}
+
+		changes := [][]string{}
+		for _, m := range diff.Modified {
+			changes = append(changes, m.Changes)
+		}
+
+		if tt.modified == nil {
+			tt.modified = [][]string{}
+		}
+
+		if !reflect.DeepEqual(changes, tt.modified) {
+			t.Errorf("%s: modified %v, want %v", tt.name, changes, tt.modified)
+		}
+	}
+}
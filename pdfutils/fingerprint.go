@@ -0,0 +1,125 @@
+package pdfutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	"github.com/mgmeyers/unipdf/v3/core"
+	"github.com/mgmeyers/unipdf/v3/model"
+)
+
+func writeContentStreams(h hash.Hash, obj core.PdfObject) {
+	switch t := core.TraceToDirectObject(obj).(type) {
+	case *core.PdfObjectStream:
+		h.Write(t.Stream)
+	case *core.PdfObjectArray:
+		for _, o := range t.Elements() {
+			writeContentStreams(h, o)
+		}
+	}
+}
+
+// Writes an object with every reference resolved, so that changes to the
+// objects it points to, such as the bytes of an image XObject, change the
+// hash. Objects that were already written are skipped to avoid cycles.
+func writeResolvedObject(h hash.Hash, obj core.PdfObject, seen map[core.PdfObject]bool) {
+	obj = core.TraceToDirectObject(obj)
+
+	switch t := obj.(type) {
+	case *core.PdfObjectDictionary:
+		if seen[t] {
+			h.Write([]byte("<seen>"))
+			return
+		}
+		seen[t] = true
+
+		h.Write([]byte("<<"))
+		for _, key := range t.Keys() {
+			h.Write([]byte(key.WriteString()))
+			writeResolvedObject(h, t.Get(key), seen)
+		}
+		h.Write([]byte(">>"))
+	case *core.PdfObjectArray:
+		if seen[t] {
+			h.Write([]byte("<seen>"))
+			return
+		}
+		seen[t] = true
+
+		h.Write([]byte("["))
+		for _, o := range t.Elements() {
+			writeResolvedObject(h, o, seen)
+		}
+		h.Write([]byte("]"))
+	case *core.PdfObjectStream:
+		if seen[t] {
+			h.Write([]byte("<seen>"))
+			return
+		}
+		seen[t] = true
+
+		writeResolvedObject(h, t.PdfObjectDictionary, seen)
+		fmt.Fprintf(h, "stream %d\n", len(t.Stream))
+		h.Write(t.Stream)
+	case nil:
+		h.Write([]byte("null"))
+	default:
+		h.Write([]byte(t.WriteString()))
+	}
+
+	h.Write([]byte{' '})
+}
+
+// GetPageContentFingerprint hashes what is drawn on a page: its geometry,
+// its content streams, and the resources they use.
+func GetPageContentFingerprint(page *model.PdfPage) string {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%v|%v|%d\n", *page.MediaBox, *page.CropBox, *page.Rotate)
+
+	writeContentStreams(h, page.Contents)
+
+	if r := page.Resources; r != nil {
+		seen := map[core.PdfObject]bool{}
+		for _, obj := range []core.PdfObject{r.ExtGState, r.ColorSpace, r.Pattern, r.Shading, r.XObject, r.Font, r.ProcSet, r.Properties} {
+			writeResolvedObject(h, obj, seen)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GetOutlineKey identifies the outline inferred from pages with the given
+// content fingerprints.
+func GetOutlineKey(fingerprints []string) string {
+	h := sha256.New()
+
+	for _, f := range fingerprints {
+		fmt.Fprintln(h, f)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GetPageFingerprint hashes everything that affects the annotations
+// extracted from a page: its position, its content, and the dictionaries of
+// its annotations.
+func GetPageFingerprint(pageIndex int, pageLabel string, page *model.PdfPage, annotations []*model.PdfAnnotation) string {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%d|%s|%s\n", pageIndex, pageLabel, GetPageContentFingerprint(page))
+
+	for _, a := range annotations {
+		obj := core.TraceToDirectObject(a.GetContainingPdfObject())
+
+		if obj != nil {
+			h.Write([]byte(obj.WriteString()))
+		}
+
+		h.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -141,6 +141,50 @@ func GetCoordinates(annotation *model.PdfAnnotation) (float64, float64) {
 	return x, y
 }
 
+func GetAnnotationRect(annotation *model.PdfAnnotation) []float64 {
+	objArr, ok := annotation.Rect.(*core.PdfObjectArray)
+	if !ok {
+		return nil
+	}
+
+	annotRect, err := objArr.ToFloat64Array()
+	if err != nil || len(annotRect) < 4 {
+		return nil
+	}
+
+	round := func(f float64) float64 {
+		return math.Round(f*100) / 100
+	}
+
+	return []float64{
+		round(math.Min(annotRect[0], annotRect[2])),
+		round(math.Min(annotRect[1], annotRect[3])),
+		round(math.Max(annotRect[0], annotRect[2])),
+		round(math.Max(annotRect[1], annotRect[3])),
+	}
+}
+
+// GetAnnotationQuads returns the bounds of each of the annotation's quads as
+// [llx, lly, urx, ury]
+func GetAnnotationQuads(page *model.PdfPage, annotation *model.PdfAnnotation) [][]float64 {
+	rects := GetAnnotationRects(page, annotation)
+	if len(rects) == 0 {
+		return nil
+	}
+
+	round := func(f float64) float64 {
+		return math.Round(f*100) / 100
+	}
+
+	quads := [][]float64{}
+
+	for _, r := range rects {
+		quads = append(quads, []float64{round(r.X.Lo), round(r.Y.Lo), round(r.X.Hi), round(r.Y.Hi)})
+	}
+
+	return quads
+}
+
 func distanceBetween(x1, y1, x2, y2 float64) float64 {
 	return math.Sqrt(math.Pow(x1-x2, 2.0) + math.Pow(y1-y2, 2.0))
 }
@@ -0,0 +1,240 @@
+package pdfutils
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/mgmeyers/unipdf/v3/core"
+	"github.com/mgmeyers/unipdf/v3/extractor"
+	"github.com/mgmeyers/unipdf/v3/model"
+)
+
+// TextLine is a line of text on a page, as used to infer headings
+type TextLine struct {
+	Text  string
+	Page  int
+	Top   float64
+	Size  float64
+	Bold  bool
+	Chars int
+}
+
+const (
+	fontFlagForceBold = 0x40000
+	maxHeadingLevels  = 4
+	maxHeadingWords   = 15
+)
+
+var boldFontNames = []string{"bold", "black", "heavy", "semibold", "demi"}
+
+func isBoldFont(font *model.PdfFont) bool {
+	if font == nil {
+		return false
+	}
+
+	name := strings.ToLower(font.BaseFont())
+
+	for _, b := range boldFontNames {
+		if strings.Contains(name, b) {
+			return true
+		}
+	}
+
+	desc := font.FontDescriptor()
+	if desc == nil {
+		return false
+	}
+
+	if weight, err := core.GetNumberAsFloat(core.TraceToDirectObject(desc.FontWeight)); err == nil && weight >= 600 {
+		return true
+	}
+
+	flags, ok := core.GetIntVal(core.TraceToDirectObject(desc.Flags))
+
+	return ok && flags&fontFlagForceBold != 0
+}
+
+// Font sizes are rounded so that lines set in the same size compare equal
+func roundFontSize(size float64) float64 {
+	return math.Round(size*2) / 2
+}
+
+// GetTextLines groups the text marks of a page into lines. A line is bold
+// when all of its characters are.
+func GetTextLines(marks []extractor.TextMark, page int) []*TextLine {
+	lines := []*TextLine{}
+	var line *TextLine
+	text := strings.Builder{}
+
+	end := func() {
+		if line != nil && line.Chars > 0 {
+			line.Text = strings.TrimSpace(text.String())
+			lines = append(lines, line)
+		}
+
+		line = nil
+		text.Reset()
+	}
+
+	for _, mark := range marks {
+		if mark.Meta && strings.Contains(mark.Text, "\n") {
+			end()
+			continue
+		}
+
+		if line == nil {
+			line = &TextLine{Page: page, Bold: true}
+		}
+
+		text.WriteString(mark.Text)
+
+		if mark.Meta || strings.TrimSpace(mark.Text) == "" {
+			continue
+		}
+
+		line.Chars += len([]rune(mark.Text))
+		line.Top = math.Max(line.Top, mark.BBox.Ury)
+		line.Size = math.Max(line.Size, roundFontSize(mark.FontSize))
+		line.Bold = line.Bold && isBoldFont(mark.Font)
+	}
+
+	end()
+
+	return lines
+}
+
+// The body text size is the size most characters are set in
+func getBodyFontSize(lines []*TextLine) float64 {
+	counts := map[float64]int{}
+	body := 0.0
+
+	for _, line := range lines {
+		counts[line.Size] += line.Chars
+
+		if counts[line.Size] > counts[body] {
+			body = line.Size
+		}
+	}
+
+	return body
+}
+
+func isHeadingText(text string) bool {
+	words := strings.Fields(text)
+	if len(words) == 0 || len(words) > maxHeadingWords {
+		return false
+	}
+
+	hasLetter := false
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			hasLetter = true
+			break
+		}
+	}
+
+	// Sentences are body text, even when emphasized
+	return hasLetter && !strings.HasSuffix(text, ".") && !strings.HasSuffix(text, ",")
+}
+
+type headingStyle struct {
+	size float64
+	bold bool
+}
+
+// InferOutline builds an outline from lines that are set larger than the
+// body text, or in bold at the body text size. Each distinct style is a
+// heading level, larger sizes first. Consecutive lines in the same style are
+// joined, so headings may wrap.
+func InferOutline(lines []*TextLine) []*OutlineEntry {
+	body := getBodyFontSize(lines)
+	if body == 0 {
+		return nil
+	}
+
+	candidates := []*TextLine{}
+	styleLines := map[headingStyle]int{}
+
+	// Index of the last line added to a candidate, so that only the line
+	// directly below it is joined to it
+	lastLine := -1
+
+	for i, line := range lines {
+		if line.Size < body || (line.Size < body*1.15 && !line.Bold) {
+			continue
+		}
+
+		style := headingStyle{line.Size, line.Bold}
+
+		if len(candidates) > 0 && lastLine == i-1 {
+			prev := candidates[len(candidates)-1]
+			last := lines[lastLine]
+
+			if last.Page == line.Page && last.Size == line.Size && last.Bold == line.Bold &&
+				last.Top-line.Top < line.Size*2 {
+				prev.Text += " " + line.Text
+				lastLine = i
+				continue
+			}
+		}
+
+		lastLine = i
+
+		candidates = append(candidates, &TextLine{
+			Text: line.Text,
+			Page: line.Page,
+			Top:  line.Top,
+			Size: line.Size,
+			Bold: line.Bold,
+		})
+		styleLines[style]++
+	}
+
+	// A style that covers too many lines is emphasized body text rather
+	// than headings
+	maxLines := int(math.Max(10, float64(len(lines))*0.05))
+	styles := []headingStyle{}
+
+	for style, count := range styleLines {
+		if count <= maxLines {
+			styles = append(styles, style)
+		}
+	}
+
+	sort.Slice(styles, func(i, j int) bool {
+		if styles[i].size != styles[j].size {
+			return styles[i].size > styles[j].size
+		}
+
+		return styles[i].bold
+	})
+
+	if len(styles) > maxHeadingLevels {
+		styles = styles[:maxHeadingLevels]
+	}
+
+	levels := map[headingStyle]int{}
+	for i, style := range styles {
+		levels[style] = i + 1
+	}
+
+	outline := []*OutlineEntry{}
+
+	for _, c := range candidates {
+		level, ok := levels[headingStyle{c.Size, c.Bold}]
+		if !ok || !isHeadingText(c.Text) {
+			continue
+		}
+
+		outline = append(outline, &OutlineEntry{
+			Title: c.Text,
+			Level: level,
+			Page:  c.Page,
+			Top:   math.Round(c.Top*10) / 10,
+		})
+	}
+
+	return outline
+}
@@ -0,0 +1,69 @@
+package pdfutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func bodyLines(page int, top float64, n int) []*TextLine {
+	lines := []*TextLine{}
+
+	for i := 0; i < n; i++ {
+		lines = append(lines, &TextLine{
+			Text:  "Body text that runs across the whole line of the page",
+			Page:  page,
+			Top:   top - float64(i)*12,
+			Size:  10,
+			Chars: 53,
+		})
+	}
+
+	return lines
+}
+
+func heading(text string, page int, top float64, size float64, bold bool) *TextLine {
+	return &TextLine{Text: text, Page: page, Top: top, Size: size, Bold: bold, Chars: len(text)}
+}
+
+func TestInferOutline(t *testing.T) {
+	lines := []*TextLine{
+		heading("1 Introduction", 1, 700, 14, true),
+	}
+	lines = append(lines, bodyLines(1, 680, 20)...)
+	lines = append(lines,
+		heading("2 A heading long enough that it", 1, 400, 14, true),
+		heading("wraps onto a second line", 1, 383, 14, true),
+		heading("2.1 Participants", 1, 360, 10, true),
+	)
+	lines = append(lines, bodyLines(1, 340, 20)...)
+	lines = append(lines,
+		// Emphasized sentences are body text
+		heading("This sentence is set in bold.", 2, 700, 10, true),
+		heading("3 Results", 2, 600, 14, true),
+		// The next heading in the same style isn't joined to it
+		heading("4 Discussion", 2, 300, 14, true),
+	)
+	lines = append(lines, bodyLines(2, 280, 20)...)
+
+	want := []*OutlineEntry{
+		{Title: "1 Introduction", Level: 1, Page: 1, Top: 700},
+		{Title: "2 A heading long enough that it wraps onto a second line", Level: 1, Page: 1, Top: 400},
+		{Title: "2.1 Participants", Level: 2, Page: 1, Top: 360},
+		{Title: "3 Results", Level: 1, Page: 2, Top: 600},
+		{Title: "4 Discussion", Level: 1, Page: 2, Top: 300},
+	}
+
+	got := InferOutline(lines)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InferOutline() =")
+		for _, e := range got {
+			t.Errorf("  %+v", e)
+		}
+	}
+}
+
+func TestInferOutlineWithoutText(t *testing.T) {
+	if got := InferOutline(nil); got != nil {
+		t.Errorf("InferOutline(nil) = %v, want nil", got)
+	}
+}
@@ -60,6 +60,43 @@ func GetAnnotationDate(annot *model.PdfAnnotation) *time.Time {
 	return &date
 }
 
+func GetAnnotationName(annot *model.PdfAnnotation) string {
+	nm, ok := core.GetString(annot.NM)
+	if !ok {
+		return ""
+	}
+
+	return RemoveNul(nm.Decoded())
+}
+
+func GetAnnotationAuthor(annot *model.PdfAnnotation) string {
+	var markup *model.PdfAnnotationMarkup
+
+	switch ctx := annot.GetContext().(type) {
+	case *model.PdfAnnotationHighlight:
+		markup = ctx.PdfAnnotationMarkup
+	case *model.PdfAnnotationStrikeOut:
+		markup = ctx.PdfAnnotationMarkup
+	case *model.PdfAnnotationUnderline:
+		markup = ctx.PdfAnnotationMarkup
+	case *model.PdfAnnotationSquare:
+		markup = ctx.PdfAnnotationMarkup
+	case *model.PdfAnnotationText:
+		markup = ctx.PdfAnnotationMarkup
+	}
+
+	if markup == nil {
+		return ""
+	}
+
+	t, ok := core.GetString(markup.T)
+	if !ok {
+		return ""
+	}
+
+	return RemoveNul(t.Decoded())
+}
+
 func GetAnnotationType(t interface{}) string {
 	switch t.(type) {
 	case *model.PdfAnnotationHighlight:
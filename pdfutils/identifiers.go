@@ -0,0 +1,154 @@
+package pdfutils
+
+import (
+	"regexp"
+	"strings"
+)
+
+type Identifiers struct {
+	DOI   string `json:"doi,omitempty"`
+	ArXiv string `json:"arxiv,omitempty"`
+	ISBN  string `json:"isbn,omitempty"`
+}
+
+var (
+	doiRegex      = regexp.MustCompile(`(?i)\b(10\.\d{4,9}/[-._;()/:a-z0-9]+[a-z0-9])`)
+	arxivRegex    = regexp.MustCompile(`(?i)arxiv(?:\.org/abs/|:\s*|\s+)(\d{4}\.\d{4,5}|[a-z-]+(?:\.[a-z]{2})?/\d{7})(?:v\d+)?`)
+	arxivDOIRegex = regexp.MustCompile(`(?i)^10\.48550/arxiv\.(.+)$`)
+	isbnRegex     = regexp.MustCompile(`(?i)\bISBN(?:-1[03])?:?\s*((?:97[89][- ]?)?(?:\d[- ]?){9}[\dx])\b`)
+
+	referencesRegex = regexp.MustCompile(`(?im)^[ \t]*(?:\d+\.?[ \t]*)?(?:references|bibliography|works cited|literature cited)[ \t]*$`)
+)
+
+func NormalizeDOI(doi string) string {
+	doi = strings.TrimSpace(strings.ToLower(doi))
+
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi:"} {
+		doi = strings.TrimPrefix(doi, prefix)
+	}
+
+	return strings.TrimRight(doi, ".,;")
+}
+
+// NormalizeArXiv strips the version from an arXiv identifier
+func NormalizeArXiv(id string) string {
+	id = strings.TrimSpace(strings.ToLower(id))
+	id = strings.TrimPrefix(id, "arxiv:")
+
+	if i := strings.LastIndex(id, "v"); i > 0 && i > strings.LastIndexAny(id, "./") {
+		id = id[:i]
+	}
+
+	return id
+}
+
+func isValidISBN(isbn string) bool {
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, r := range isbn {
+			d := int(r - '0')
+			if r == 'X' && i == 9 {
+				d = 10
+			} else if r < '0' || r > '9' {
+				return false
+			}
+			sum += d * (10 - i)
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, r := range isbn {
+			if r < '0' || r > '9' {
+				return false
+			}
+			if i%2 == 0 {
+				sum += int(r - '0')
+			} else {
+				sum += 3 * int(r-'0')
+			}
+		}
+		return sum%10 == 0
+	}
+
+	return false
+}
+
+// NormalizeISBN returns the ISBN-13 form of an ISBN, or an empty string if
+// it isn't valid
+func NormalizeISBN(isbn string) string {
+	isbn = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(isbn)))
+
+	if !isValidISBN(isbn) {
+		return ""
+	}
+
+	if len(isbn) == 13 {
+		return isbn
+	}
+
+	isbn = "978" + isbn[:9]
+	sum := 0
+
+	for i, r := range isbn {
+		if i%2 == 0 {
+			sum += int(r - '0')
+		} else {
+			sum += 3 * int(r-'0')
+		}
+	}
+
+	return isbn + string(rune('0'+(10-sum%10)%10))
+}
+
+// TrimReferences cuts a page's text off at a "References" or "Bibliography"
+// heading, since the identifiers after it belong to other works. The second
+// value reports whether a heading was found.
+func TrimReferences(text string) (string, bool) {
+	loc := referencesRegex.FindStringIndex(text)
+	if loc == nil {
+		return text, false
+	}
+
+	return text[:loc[0]], true
+}
+
+// FindIdentifiers looks for the first DOI, arXiv identifier, and ISBN in each
+// of the texts, in order. Identifiers found in earlier texts take precedence.
+func FindIdentifiers(texts ...string) *Identifiers {
+	ids := &Identifiers{}
+
+	for _, text := range texts {
+		if ids.DOI == "" {
+			if m := doiRegex.FindStringSubmatch(text); m != nil {
+				ids.DOI = NormalizeDOI(m[1])
+			}
+		}
+
+		if ids.ArXiv == "" {
+			if m := arxivRegex.FindStringSubmatch(text); m != nil {
+				ids.ArXiv = NormalizeArXiv(m[1])
+			}
+		}
+
+		if ids.ISBN == "" {
+			for _, m := range isbnRegex.FindAllStringSubmatch(text, -1) {
+				if isbn := NormalizeISBN(m[1]); isbn != "" {
+					ids.ISBN = isbn
+					break
+				}
+			}
+		}
+	}
+
+	// arXiv papers are assigned DOIs under their own prefix
+	if m := arxivDOIRegex.FindStringSubmatch(ids.DOI); m != nil && ids.ArXiv == "" {
+		ids.ArXiv = NormalizeArXiv(m[1])
+	}
+
+	if *ids == (Identifiers{}) {
+		return nil
+	}
+
+	return ids
+}
@@ -0,0 +1,107 @@
+package pdfutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want string
+	}{
+		{"0-306-40615-2", "9780306406157"},
+		{"080442957X", "9780804429573"},
+		{"080442957x", "9780804429573"},
+		{"978-0-306-40615-7", "9780306406157"},
+		{"978 0 306 40615 7", "9780306406157"},
+		{"0-306-40615-3", ""},
+		{"978-0-306-40615-6", ""},
+		{"X804429570", ""},
+		{"12345", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeISBN(tt.isbn); got != tt.want {
+			t.Errorf("NormalizeISBN(%q) = %q, want %q", tt.isbn, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeArXiv(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"2101.00001", "2101.00001"},
+		{"2101.00001v3", "2101.00001"},
+		{"arXiv:2101.00001v2", "2101.00001"},
+		{"hep-th/9901001v1", "hep-th/9901001"},
+		{"math.GT/0309136", "math.gt/0309136"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeArXiv(tt.id); got != tt.want {
+			t.Errorf("NormalizeArXiv(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestFindIdentifiers(t *testing.T) {
+	tests := []struct {
+		name  string
+		texts []string
+		want  *Identifiers
+	}{
+		{
+			name:  "none",
+			texts: []string{"", "no identifiers here"},
+			want:  nil,
+		},
+		{
+			name:  "metadata before text",
+			texts: []string{"<prism:doi>10.1000/META</prism:doi>", "https://doi.org/10.1000/page.1."},
+			want:  &Identifiers{DOI: "10.1000/meta"},
+		},
+		{
+			name:  "first page first",
+			texts: []string{"", "doi:10.1000/first", "doi:10.1000/second"},
+			want:  &Identifiers{DOI: "10.1000/first"},
+		},
+		{
+			name:  "arxiv from doi",
+			texts: []string{"https://doi.org/10.48550/arXiv.2101.00001"},
+			want:  &Identifiers{DOI: "10.48550/arxiv.2101.00001", ArXiv: "2101.00001"},
+		},
+		{
+			name:  "arxiv and isbn",
+			texts: []string{"arXiv:2101.00001v2 [cs.CL]", "ISBN 0-306-40615-3 ISBN 978-0-306-40615-7"},
+			want:  &Identifiers{ArXiv: "2101.00001", ISBN: "9780306406157"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := FindIdentifiers(tt.texts...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: FindIdentifiers() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrimReferences(t *testing.T) {
+	tests := []struct {
+		text         string
+		want         string
+		isReferences bool
+	}{
+		{"Body doi:10.1000/own\nReferences\n[1] doi:10.1000/other", "Body doi:10.1000/own\n", true},
+		{"Body\n7. Bibliography\n[1] Smith", "Body\n", true},
+		{"As the references show, doi:10.1000/own", "As the references show, doi:10.1000/own", false},
+	}
+
+	for _, tt := range tests {
+		got, isReferences := TrimReferences(tt.text)
+		if got != tt.want || isReferences != tt.isReferences {
+			t.Errorf("TrimReferences(%q) = %q, %v, want %q, %v", tt.text, got, isReferences, tt.want, tt.isReferences)
+		}
+	}
+}
@@ -1,15 +1,19 @@
 package pdfutils
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"math"
 	"os"
 	"time"
 
 	"github.com/mgmeyers/unipdf/v3/core"
+	"github.com/mgmeyers/unipdf/v3/extractor"
 	"github.com/mgmeyers/unipdf/v3/model"
 )
 
@@ -18,6 +22,7 @@ type ImageAnnotArgs struct {
 	PageImg         *image.Image
 	OCRImg          *image.Image
 	PageIndex       int
+	PageLabel       string
 	Annotation      *model.PdfAnnotation
 	X               float64
 	Y               float64
@@ -31,24 +36,27 @@ type ImageAnnotArgs struct {
 	TessPath        string
 	TessLang        string
 	TessDataDir     string
-}
 
-func HandleImageAnnot(args ImageAnnotArgs) (*Annotation, error) {
-	page := args.Page
-	width := page.CropBox.Width()
-	height := page.CropBox.Height()
+	// Embedded images of the page. When set, the image under the rectangle
+	// is saved instead of a render of the page, if there is one
+	NativeImages     []extractor.ImageMark
+	ClipNativeImages bool
 
-	ctx := args.Annotation.GetContext()
+	// SVG of the page. When set, the rectangle is saved as an SVG clipped
+	// from it instead of a render of the page
+	PageSVG *string
 
-	objArr, ok := ctx.(*model.PdfAnnotationSquare).Rect.(*core.PdfObjectArray)
-	if !ok {
-		return nil, nil
-	}
+	// Embeds the image in the annotation as ImageData instead of writing it
+	Inline  bool
+	DataURI bool
+}
 
-	annotRect, err := objArr.ToFloat64Array()
-	if err != nil {
-		return nil, err
-	}
+// GetPageImageRect converts a rectangle in PDF user space to the top-left
+// oriented coordinates of the rendered page, accounting for the page's
+// rotation and crop box. It also returns the width of the rendered page.
+func GetPageImageRect(page *model.PdfPage, rect []float64) ([]float64, float64) {
+	width := page.CropBox.Width()
+	height := page.CropBox.Height()
 
 	xAdjust := page.MediaBox.Llx - page.CropBox.Llx
 	yAdjust := page.MediaBox.Lly - page.CropBox.Lly
@@ -74,12 +82,170 @@ func HandleImageAnnot(args ImageAnnotArgs) (*Annotation, error) {
 		yAdjust = page.CropBox.Urx - page.MediaBox.Urx
 	}
 
-	annotRect = ApplyPageRotation(args.Page, annotRect)
+	imageRect := ApplyPageRotation(page, rect)
+
+	imageRect[0] = imageRect[0] + xAdjust
+	imageRect[1] = height - (imageRect[1] + yAdjust)
+	imageRect[2] = imageRect[2] + xAdjust
+	imageRect[3] = height - (imageRect[3] + yAdjust)
+
+	return imageRect, width
+}
+
+// CropPageImage crops a rendered page to a rectangle returned by
+// GetPageImageRect.
+func CropPageImage(pageImg *image.Image, imageRect []float64, width float64) (image.Image, error) {
+	scale := float64((*pageImg).Bounds().Max.X) / width
+
+	crop := image.Rect(
+		int(math.Round(imageRect[0]*scale)),
+		int(math.Round(imageRect[1]*scale)),
+		int(math.Round(imageRect[2]*scale)),
+		int(math.Round(imageRect[3]*scale)),
+	)
+
+	return CropImage(pageImg, crop)
+}
+
+// GetUnionImageRect returns the smallest rectangle of the rendered page that
+// contains all of the rects, which are in PDF user space, grown by padding
+// points on each side. It also returns the width of the rendered page.
+func GetUnionImageRect(page *model.PdfPage, rects [][]float64, padding float64) ([]float64, float64) {
+	var union []float64
+	var width float64
+
+	for _, r := range rects {
+		var imageRect []float64
+		imageRect, width = GetPageImageRect(page, append([]float64{}, r...))
+
+		x0, x1 := math.Min(imageRect[0], imageRect[2]), math.Max(imageRect[0], imageRect[2])
+		y0, y1 := math.Min(imageRect[1], imageRect[3]), math.Max(imageRect[1], imageRect[3])
+
+		if union == nil {
+			union = []float64{x0, y0, x1, y1}
+			continue
+		}
+
+		union[0] = math.Min(union[0], x0)
+		union[1] = math.Min(union[1], y0)
+		union[2] = math.Max(union[2], x1)
+		union[3] = math.Max(union[3], y1)
+	}
+
+	if union == nil {
+		return nil, width
+	}
+
+	union[0] -= padding
+	union[1] -= padding
+	union[2] += padding
+	union[3] += padding
+
+	return union, width
+}
+
+// EncodeAnnotationImage crops the union of rects from the rendered page and
+// encodes it. Crops are clipped to the page. When pageSVG is set, the crop is
+// clipped from it instead.
+func EncodeAnnotationImage(page *model.PdfPage, pageImg *image.Image, pageSVG *string, rects [][]float64, padding float64, format string, quality int) ([]byte, error) {
+	imageRect, width := GetUnionImageRect(page, rects, padding)
+	if imageRect == nil {
+		return nil, fmt.Errorf("Error: annotation has no area")
+	}
+
+	if pageSVG != nil {
+		svg, err := ClipSVG(*pageSVG, imageRect)
+		return []byte(svg), err
+	}
+
+	cropped, err := CropPageImage(pageImg, imageRect, width)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := EncodeImage(buf, &cropped, format, quality); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// GetImageData encodes an image for embedding in the output, as base64 or as
+// a data URI
+func GetImageData(data []byte, format string, dataURI bool) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if !dataURI {
+		return encoded
+	}
+
+	return "data:" + GetImageMimeType(format) + ";base64," + encoded
+}
+
+func GetImageMimeType(format string) string {
+	switch format {
+	case "png":
+		return "image/png"
+	case "svg":
+		return "image/svg+xml"
+	}
+
+	return "image/jpeg"
+}
+
+func encodeImageAnnot(args ImageAnnotArgs, pdfRect []float64, imageRect []float64, width float64) ([]byte, error) {
+	if args.PageSVG != nil {
+		svg, err := ClipSVG(*args.PageSVG, imageRect)
+		return []byte(svg), err
+	}
+
+	// JPEGs are saved as they are embedded, rather than compressed again
+	if args.NativeImages != nil && !args.ClipNativeImages && args.ImageFormat == "jpg" {
+		if data := GetNativeJPEG(args.Page, args.NativeImages, pdfRect); data != nil {
+			return data, nil
+		}
+	}
 
-	annotRect[0] = annotRect[0] + xAdjust
-	annotRect[1] = height - (annotRect[1] + yAdjust)
-	annotRect[2] = annotRect[2] + xAdjust
-	annotRect[3] = height - (annotRect[3] + yAdjust)
+	var cropped image.Image
+	var err error
+
+	if args.NativeImages != nil {
+		cropped, err = GetNativeImage(args.NativeImages, pdfRect, args.ClipNativeImages)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cropped == nil {
+		cropped, err = CropPageImage(args.PageImg, imageRect, width)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := EncodeImage(buf, &cropped, args.ImageFormat, args.ImageQuality); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func HandleImageAnnot(args ImageAnnotArgs) (*Annotation, error) {
+	ctx := args.Annotation.GetContext()
+
+	objArr, ok := ctx.(*model.PdfAnnotationSquare).Rect.(*core.PdfObjectArray)
+	if !ok {
+		return nil, nil
+	}
+
+	annotRect, err := objArr.ToFloat64Array()
+	if err != nil {
+		return nil, err
+	}
+
+	pdfRect := append([]float64{}, annotRect...)
+	annotRect, width := GetPageImageRect(args.Page, annotRect)
 
 	if args.Write {
 		if _, err := os.Stat(args.ImageOutputPath); os.IsNotExist(err) {
@@ -101,27 +267,18 @@ func HandleImageAnnot(args ImageAnnotArgs) (*Annotation, error) {
 		args.ImageFormat,
 	)
 
-	if args.Write {
-		scale := float64((*args.PageImg).Bounds().Max.X) / width
-
-		crop := image.Rect(
-			int(math.Round(annotRect[0]*scale)),
-			int(math.Round(annotRect[1]*scale)),
-			int(math.Round(annotRect[2]*scale)),
-			int(math.Round(annotRect[3]*scale)),
-		)
+	imageData := ""
 
-		cropped, err := CropImage(args.PageImg, crop)
+	if args.Write || args.Inline {
+		data, err := encodeImageAnnot(args, pdfRect, annotRect, width)
 		if err != nil {
 			return nil, err
 		}
 
-		if err := WriteImage(
-			&cropped,
-			imagePath,
-			args.ImageFormat,
-			args.ImageQuality,
-		); err != nil {
+		if args.Inline {
+			imagePath = ""
+			imageData = GetImageData(data, args.ImageFormat, args.DataURI)
+		} else if err := os.WriteFile(imagePath, data, 0644); err != nil {
 			return nil, err
 		}
 	}
@@ -133,12 +290,17 @@ func HandleImageAnnot(args ImageAnnotArgs) (*Annotation, error) {
 	}
 
 	builtAnnot := &Annotation{
+		Author:        GetAnnotationAuthor(args.Annotation),
 		Color:         GetAnnotationColor(args.Annotation),
 		ColorCategory: GetAnnotationColorCategory(args.Annotation),
 		Comment:       comment,
 		ImagePath:     imagePath,
+		ImageData:     imageData,
+		Name:          GetAnnotationName(args.Annotation),
 		Type:          Image,
 		Page:          args.PageIndex + 1,
+		PageLabel:     args.PageLabel,
+		Rect:          GetAnnotationRect(args.Annotation),
 		X:             args.X,
 		Y:             args.Y,
 		ID:            args.ID,
@@ -171,16 +333,7 @@ func HandleImageOCR(
 		width = page.CropBox.Height()
 	}
 
-	ocrScale := float64((*ocrImg).Bounds().Max.X) / width
-
-	ocrCrop := image.Rect(
-		int(math.Round(annotRect[0]*ocrScale)),
-		int(math.Round(annotRect[1]*ocrScale)),
-		int(math.Round(annotRect[2]*ocrScale)),
-		int(math.Round(annotRect[3]*ocrScale)),
-	)
-
-	ocrCropped, err := CropImage(ocrImg, ocrCrop)
+	ocrCropped, err := CropPageImage(ocrImg, annotRect, width)
 	if err != nil {
 		return ""
 	}
@@ -206,30 +359,20 @@ func CropImage(img *image.Image, crop image.Rectangle) (image.Image, error) {
 	return simg.SubImage(crop), nil
 }
 
-func WriteImage(img *image.Image, name string, format string, quality int) error {
+func EncodeImage(w io.Writer, img *image.Image, format string, quality int) error {
 	if format == "jpg" {
-		return writeJPGImage(img, name, quality)
+		return jpeg.Encode(w, *img, &jpeg.Options{Quality: quality})
 	}
 
-	return writePNGImage(img, name)
+	return png.Encode(w, *img)
 }
 
-func writeJPGImage(img *image.Image, name string, quality int) error {
-	fd, err := os.Create(name)
-	if err != nil {
-		return err
-	}
-
-	defer fd.Close()
-	return jpeg.Encode(fd, *img, &jpeg.Options{Quality: quality})
-}
-
-func writePNGImage(img *image.Image, name string) error {
+func WriteImage(img *image.Image, name string, format string, quality int) error {
 	fd, err := os.Create(name)
 	if err != nil {
 		return err
 	}
 
 	defer fd.Close()
-	return png.Encode(fd, *img)
+	return EncodeImage(fd, img, format, quality)
 }
@@ -0,0 +1,93 @@
+package pdfutils
+
+import (
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/mgmeyers/unipdf/v3/core"
+	"github.com/mgmeyers/unipdf/v3/model"
+)
+
+func getTrailerDict(reader *model.PdfReader, key string) *core.PdfObjectDictionary {
+	trailer, err := reader.GetTrailer()
+	if err != nil || trailer == nil {
+		return nil
+	}
+
+	dict, _ := core.GetDict(core.TraceToDirectObject(trailer.Get(core.PdfObjectName(key))))
+
+	return dict
+}
+
+// GetInfoEntry returns a string from the document information dictionary
+func GetInfoEntry(reader *model.PdfReader, key string) string {
+	info := getTrailerDict(reader, "Info")
+	if info == nil {
+		return ""
+	}
+
+	str, ok := core.GetString(core.TraceToDirectObject(info.Get(core.PdfObjectName(key))))
+	if !ok {
+		return ""
+	}
+
+	return RemoveNul(str.Decoded())
+}
+
+// GetXMPMetadata returns the XMP packet of the document catalog, if any
+func GetXMPMetadata(reader *model.PdfReader) string {
+	catalog := getTrailerDict(reader, "Root")
+	if catalog == nil {
+		return ""
+	}
+
+	stream, ok := core.GetStream(core.TraceToDirectObject(catalog.Get("Metadata")))
+	if !ok {
+		return ""
+	}
+
+	data, err := core.DecodeStream(stream)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// FormatPdfDate converts a PDF date string, eg. "D:20220314191000Z", to
+// RFC 3339. Dates that can't be parsed are returned as is.
+func FormatPdfDate(date string) string {
+	date = strings.TrimSpace(date)
+	if date == "" {
+		return ""
+	}
+
+	d, err := model.NewPdfDate(date)
+	if err != nil {
+		return date
+	}
+
+	return d.ToGoTime().Format(time.RFC3339)
+}
+
+// GetFileID returns the first element of the trailer's file identifier as
+// lowercase hex, as used by PDF viewers to fingerprint a document
+func GetFileID(reader *model.PdfReader) string {
+	trailer, err := reader.GetTrailer()
+	if err != nil || trailer == nil {
+		return ""
+	}
+
+	ids, ok := core.GetArray(core.TraceToDirectObject(trailer.Get("ID")))
+	if !ok || ids.Len() == 0 {
+		return ""
+	}
+
+	str, ok := core.GetString(core.TraceToDirectObject(ids.Get(0)))
+	if !ok {
+		return ""
+	}
+
+	return hex.EncodeToString(str.Bytes())
+}
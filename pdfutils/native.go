@@ -0,0 +1,223 @@
+package pdfutils
+
+import (
+	"image"
+	"math"
+
+	"github.com/mgmeyers/unipdf/v3/core"
+	"github.com/mgmeyers/unipdf/v3/extractor"
+	"github.com/mgmeyers/unipdf/v3/model"
+)
+
+// An embedded image is only used when it covers at least this much of the
+// annotation's rectangle, so that a rectangle around a vector figure with a
+// small raster logo still gets a render of the whole figure
+const minNativeImageCoverage = 0.5
+
+func overlapArea(a []float64, b []float64) float64 {
+	w := math.Min(a[2], b[2]) - math.Max(a[0], b[0])
+	h := math.Min(a[3], b[3]) - math.Max(a[1], b[1])
+
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+
+	return w * h
+}
+
+// The extractor decodes every image to RGB, whatever its color space, so
+// images whose pixels are all gray are converted back. Other color spaces
+// can't be recovered from the decoded image.
+func toNativeImage(img image.Image) image.Image {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		return img
+	}
+
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		if rgba.Pix[i] != rgba.Pix[i+1] || rgba.Pix[i] != rgba.Pix[i+2] {
+			return img
+		}
+	}
+
+	gray := image.NewGray(rgba.Bounds())
+	for i := 0; i < len(gray.Pix); i++ {
+		gray.Pix[i] = rgba.Pix[i*4]
+	}
+
+	return gray
+}
+
+// Finds the embedded image that covers the most of rect, which is in PDF
+// user space. Returns nil when no image covers enough of the rectangle, or
+// the image is rotated. Also returns the image's bounds and the normalized
+// rectangle.
+func findNativeImage(images []extractor.ImageMark, rect []float64) (*extractor.ImageMark, []float64, []float64) {
+	r := []float64{
+		math.Min(rect[0], rect[2]),
+		math.Min(rect[1], rect[3]),
+		math.Max(rect[0], rect[2]),
+		math.Max(rect[1], rect[3]),
+	}
+
+	rectArea := (r[2] - r[0]) * (r[3] - r[1])
+	if rectArea <= 0 {
+		return nil, nil, nil
+	}
+
+	var best *extractor.ImageMark
+	var bestBounds []float64
+	bestArea := 0.0
+
+	for i, mark := range images {
+		if mark.Image == nil || mark.Angle != 0 || mark.Width <= 0 || mark.Height <= 0 {
+			continue
+		}
+
+		bounds := []float64{mark.X, mark.Y, mark.X + mark.Width, mark.Y + mark.Height}
+		area := overlapArea(r, bounds)
+
+		if area > bestArea {
+			best = &images[i]
+			bestBounds = bounds
+			bestArea = area
+		}
+	}
+
+	if best == nil || bestArea/rectArea < minNativeImageCoverage {
+		return nil, nil, nil
+	}
+
+	return best, bestBounds, r
+}
+
+// GetNativeImage returns the embedded image under rect, which is in PDF user
+// space, at its own resolution. When clip is set, only the part of the image
+// inside rect is returned. Returns nil when no image covers enough of the
+// rectangle, or the image is rotated.
+func GetNativeImage(images []extractor.ImageMark, rect []float64, clip bool) (image.Image, error) {
+	best, bestBounds, r := findNativeImage(images, rect)
+	if best == nil {
+		return nil, nil
+	}
+
+	img, err := best.Image.ToGoImage()
+	if err != nil {
+		return nil, err
+	}
+
+	img = toNativeImage(img)
+
+	if !clip {
+		return img, nil
+	}
+
+	// Images are drawn with their first row at the top
+	scaleX := float64(img.Bounds().Dx()) / best.Width
+	scaleY := float64(img.Bounds().Dy()) / best.Height
+
+	crop := image.Rect(
+		int(math.Floor((r[0]-bestBounds[0])*scaleX)),
+		int(math.Floor((bestBounds[3]-r[3])*scaleY)),
+		int(math.Ceil((r[2]-bestBounds[0])*scaleX)),
+		int(math.Ceil((bestBounds[3]-r[1])*scaleY)),
+	)
+
+	return CropImage(&img, crop)
+}
+
+// A JPEG stream can be saved as a file as is when it is the image's only
+// filter, and nothing else changes how its colors are drawn. CMYK JPEGs are
+// left out, since viewers disagree on whether to invert them.
+func isPlainJPEG(dict *core.PdfObjectDictionary) bool {
+	filter := core.TraceToDirectObject(dict.Get("Filter"))
+	if arr, ok := filter.(*core.PdfObjectArray); ok && arr.Len() == 1 {
+		filter = core.TraceToDirectObject(arr.Get(0))
+	}
+
+	if name, ok := core.GetName(filter); !ok || *name != "DCTDecode" {
+		return false
+	}
+
+	for _, key := range []core.PdfObjectName{"Decode", "SMask", "Mask", "DecodeParms"} {
+		if dict.Get(key) != nil {
+			return false
+		}
+	}
+
+	if mask, ok := core.GetBool(core.TraceToDirectObject(dict.Get("ImageMask"))); ok && bool(*mask) {
+		return false
+	}
+
+	switch cs := core.TraceToDirectObject(dict.Get("ColorSpace")).(type) {
+	case *core.PdfObjectName:
+		return *cs == "DeviceGray" || *cs == "DeviceRGB"
+	case *core.PdfObjectArray:
+		if cs.Len() != 2 {
+			return false
+		}
+
+		if name, ok := core.GetName(core.TraceToDirectObject(cs.Get(0))); !ok || *name != "ICCBased" {
+			return false
+		}
+
+		profile, ok := core.GetStream(core.TraceToDirectObject(cs.Get(1)))
+		if !ok {
+			return false
+		}
+
+		n, ok := core.GetIntVal(core.TraceToDirectObject(profile.Get("N")))
+		return ok && (n == 1 || n == 3)
+	}
+
+	return false
+}
+
+// GetNativeJPEG returns the original JPEG stream of the embedded image under
+// rect, so that it can be saved without being decoded and compressed again.
+// Images are matched to the page's image XObjects by their size. Returns nil
+// when no image covers enough of the rectangle, the match is ambiguous, or
+// the image isn't a JPEG that can be saved as is.
+func GetNativeJPEG(page *model.PdfPage, images []extractor.ImageMark, rect []float64) []byte {
+	best, _, _ := findNativeImage(images, rect)
+	if best == nil || page.Resources == nil {
+		return nil
+	}
+
+	xobjects, ok := core.GetDict(core.TraceToDirectObject(page.Resources.XObject))
+	if !ok {
+		return nil
+	}
+
+	var found *core.PdfObjectStream
+
+	for _, key := range xobjects.Keys() {
+		stream, ok := core.GetStream(core.TraceToDirectObject(xobjects.Get(key)))
+		if !ok {
+			continue
+		}
+
+		if name, ok := core.GetName(core.TraceToDirectObject(stream.Get("Subtype"))); !ok || *name != "Image" {
+			continue
+		}
+
+		width, _ := core.GetIntVal(core.TraceToDirectObject(stream.Get("Width")))
+		height, _ := core.GetIntVal(core.TraceToDirectObject(stream.Get("Height")))
+
+		if int64(width) != best.Image.Width || int64(height) != best.Image.Height {
+			continue
+		}
+
+		if found != nil && found != stream {
+			return nil
+		}
+
+		found = stream
+	}
+
+	if found == nil || !isPlainJPEG(found.PdfObjectDictionary) {
+		return nil
+	}
+
+	return found.Stream
+}
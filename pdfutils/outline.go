@@ -0,0 +1,107 @@
+package pdfutils
+
+import (
+	"math"
+	"sort"
+)
+
+// OutlineEntry is a heading of the document. Page is the 1-based page number
+// and Top the heading's position in PDF coordinates, so larger values are
+// higher on the page.
+type OutlineEntry struct {
+	Title string  `json:"title"`
+	Level int     `json:"level"`
+	Page  int     `json:"page"`
+	Top   float64 `json:"top"`
+}
+
+type outlineSection struct {
+	page  int
+	top   float64
+	order int
+	chain []string
+}
+
+// Builds the chain of headings each entry is nested under, sorted by their
+// position in the document.
+func getOutlineSections(outline []*OutlineEntry) []*outlineSection {
+	sections := []*outlineSection{}
+	stack := []*OutlineEntry{}
+
+	for i, entry := range outline {
+		for len(stack) > 0 && stack[len(stack)-1].Level >= entry.Level {
+			stack = stack[:len(stack)-1]
+		}
+
+		stack = append(stack, entry)
+
+		chain := make([]string, len(stack))
+		for j, e := range stack {
+			chain[j] = e.Title
+		}
+
+		sections = append(sections, &outlineSection{entry.Page, entry.Top, i, chain})
+	}
+
+	sort.SliceStable(sections, func(i, j int) bool {
+		a, b := sections[i], sections[j]
+
+		if a.page != b.page {
+			return a.page < b.page
+		}
+
+		if a.top != b.top {
+			return a.top > b.top
+		}
+
+		return a.order < b.order
+	})
+
+	return sections
+}
+
+func getAnnotationTop(annot *Annotation) float64 {
+	if len(annot.Rect) == 4 {
+		return (annot.Rect[1] + annot.Rect[3]) / 2
+	}
+
+	return annot.Y
+}
+
+// AssignSections sets the section of each annotation to the chain of headings
+// of the last outline entry that precedes it.
+func AssignSections(annots []*Annotation, outline []*OutlineEntry) {
+	if len(outline) == 0 {
+		return
+	}
+
+	sections := getOutlineSections(outline)
+
+	for _, annot := range annots {
+		top := getAnnotationTop(annot)
+		var section *outlineSection
+
+		for _, s := range sections {
+			if s.page > annot.Page || (s.page == annot.Page && s.top < top) {
+				break
+			}
+
+			section = s
+		}
+
+		if section != nil {
+			annot.Section = section.chain
+		}
+	}
+}
+
+// NormalizeOutlineTop converts a distance from the top of the page, as
+// returned by fitz, to PDF coordinates. Entries without a position point to
+// the top of the page.
+func NormalizeOutlineTop(top float64, pageTop float64) float64 {
+	if top <= 0 || math.IsNaN(top) {
+		return pageTop
+	}
+
+	return math.Round((pageTop-top)*10) / 10
+}
@@ -0,0 +1,93 @@
+package pdfutils
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mgmeyers/unipdf/v3/model"
+)
+
+const overlayStrokeWidth = 2
+
+var defaultOverlayColor = color.RGBA{255, 0, 0, 255}
+
+func parseHexColor(hex string) color.RGBA {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return defaultOverlayColor
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return defaultOverlayColor
+	}
+
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}
+}
+
+// Highlights are multiplied with the page, like a highlighter, so that the
+// text under them stays legible
+func multiplyRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			o := img.RGBAAt(x, y)
+			img.SetRGBA(x, y, color.RGBA{
+				uint8(uint16(o.R) * uint16(c.R) / 255),
+				uint8(uint16(o.G) * uint16(c.G) / 255),
+				uint8(uint16(o.B) * uint16(c.B) / 255),
+				255,
+			})
+		}
+	}
+}
+
+func strokeRect(img *image.RGBA, r image.Rectangle, c color.RGBA, width int) {
+	u := image.NewUniform(c)
+
+	draw.Draw(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+width), u, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Min.X, r.Max.Y-width, r.Max.X, r.Max.Y), u, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+width, r.Max.Y), u, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Max.X-width, r.Min.Y, r.Max.X, r.Max.Y), u, image.Point{}, draw.Src)
+}
+
+// DrawAnnotationOverlays draws the annotations over a render of their page,
+// in their color. Highlights are filled, and other annotations outlined.
+func DrawAnnotationOverlays(page *model.PdfPage, pageImg image.Image, annots []*Annotation) *image.RGBA {
+	img := image.NewRGBA(pageImg.Bounds())
+	draw.Draw(img, img.Bounds(), pageImg, pageImg.Bounds().Min, draw.Src)
+
+	for _, annot := range annots {
+		rects := annot.Quads
+		if len(rects) == 0 && len(annot.Rect) == 4 {
+			rects = [][]float64{annot.Rect}
+		}
+
+		c := parseHexColor(annot.Color)
+
+		for _, r := range rects {
+			imageRect, width := GetPageImageRect(page, append([]float64{}, r...))
+			scale := float64(img.Bounds().Dx()) / width
+
+			bounds := image.Rect(
+				int(math.Round(imageRect[0]*scale)),
+				int(math.Round(imageRect[1]*scale)),
+				int(math.Round(imageRect[2]*scale)),
+				int(math.Round(imageRect[3]*scale)),
+			).Add(img.Bounds().Min)
+
+			if annot.Type == Highlight {
+				multiplyRect(img, bounds, c)
+			} else {
+				strokeRect(img, bounds, c, overlayStrokeWidth)
+			}
+		}
+	}
+
+	return img
+}
@@ -0,0 +1,130 @@
+package pdfutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func GetPageLabel(labelMap map[int]string, pageIndex int) string {
+	if label, ok := labelMap[pageIndex]; ok {
+		return label
+	}
+
+	return strconv.Itoa(pageIndex + 1)
+}
+
+func findPageLabel(label string, labelMap map[int]string, numPages int, from int) int {
+	for i := from; i < numPages; i++ {
+		if GetPageLabel(labelMap, i) == label {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Resolves one end of a range to a page index. Numbers are physical page
+// numbers unless byLabel is set; anything else is a page label. Returns -1
+// when there is no such page.
+func resolvePageRef(ref string, labelMap map[int]string, numPages int, byLabel bool, from int) int {
+	if n, err := strconv.Atoi(ref); err == nil && !byLabel {
+		if n < 1 || n > numPages {
+			return -1
+		}
+
+		return n - 1
+	}
+
+	return findPageLabel(ref, labelMap, numPages, from)
+}
+
+// ValidatePageRanges checks the syntax of ranges, before the document they
+// apply to is read. Ranges whose ends are both numbers must not be reversed.
+func ValidatePageRanges(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "-" {
+			return fmt.Errorf("Error: invalid page range %q", spec)
+		}
+
+		split := strings.SplitN(part, "-", 2)
+		if len(split) == 1 {
+			continue
+		}
+
+		start, err1 := strconv.Atoi(strings.TrimSpace(split[0]))
+		end, err2 := strconv.Atoi(strings.TrimSpace(split[1]))
+
+		if err1 == nil && err2 == nil && start > end {
+			return fmt.Errorf("Error: page range %q ends before it starts", part)
+		}
+	}
+
+	return nil
+}
+
+// ParsePageRanges parses ranges such as "1-10,15,20-" into the set of page
+// indexes they select. Ranges that run past the last page are cut short, but
+// every part must select at least one page of this document.
+func ParsePageRanges(spec string, numPages int, labelMap map[int]string, byLabel bool) (map[int]bool, error) {
+	if err := ValidatePageRanges(spec); err != nil {
+		return nil, err
+	}
+
+	pages := map[int]bool{}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+
+		// Labels may contain dashes themselves, eg. "A-1"
+		if i := resolvePageRef(part, labelMap, numPages, byLabel, 0); i != -1 {
+			pages[i] = true
+			continue
+		}
+
+		split := strings.SplitN(part, "-", 2)
+		if len(split) == 1 {
+			return nil, fmt.Errorf("Error: page %q does not exist", part)
+		}
+
+		start := 0
+		end := numPages - 1
+
+		if s := strings.TrimSpace(split[0]); s != "" {
+			start = resolvePageRef(s, labelMap, numPages, byLabel, 0)
+
+			if start == -1 {
+				return nil, fmt.Errorf("Error: page %q does not exist", s)
+			}
+		}
+
+		if e := strings.TrimSpace(split[1]); e != "" {
+			end = resolvePageRef(e, labelMap, numPages, byLabel, start)
+
+			// A physical page past the end of the document selects up to the
+			// last page
+			if n, err := strconv.Atoi(e); end == -1 && err == nil && !byLabel && n > numPages {
+				end = numPages - 1
+			}
+
+			if end == -1 && resolvePageRef(e, labelMap, numPages, byLabel, 0) != -1 {
+				return nil, fmt.Errorf("Error: page range %q ends before it starts", part)
+			}
+
+			if end == -1 {
+				return nil, fmt.Errorf("Error: page %q does not exist", e)
+			}
+		}
+
+		if end < start {
+			return nil, fmt.Errorf("Error: page range %q ends before it starts", part)
+		}
+
+		for i := start; i <= end; i++ {
+			pages[i] = true
+		}
+	}
+
+	return pages, nil
+}
@@ -0,0 +1,87 @@
+package pdfutils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidatePageRanges(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"1", false},
+		{"1-10,15,20-", false},
+		{"-5", false},
+		{"iv-2", false},
+		{"A-1", false},
+		{"", true},
+		{"1,,2", true},
+		{"-", true},
+		{"5-2", true},
+	}
+
+	for _, tt := range tests {
+		err := ValidatePageRanges(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidatePageRanges(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParsePageRanges(t *testing.T) {
+	// Pages i-iv, then 1-6
+	labels := map[int]string{0: "i", 1: "ii", 2: "iii", 3: "iv"}
+	for i := 4; i < 10; i++ {
+		labels[i] = GetPageLabel(nil, i-4)
+	}
+
+	tests := []struct {
+		spec    string
+		byLabel bool
+		want    []int
+		wantErr bool
+	}{
+		{spec: "1", want: []int{0}},
+		{spec: "1-3,5", want: []int{0, 1, 2, 4}},
+		{spec: "8-", want: []int{7, 8, 9}},
+		{spec: "-2", want: []int{0, 1}},
+		{spec: "9-20", want: []int{8, 9}},
+		{spec: "ii-iii", want: []int{1, 2}},
+		{spec: "iv-6", want: []int{3, 4, 5}},
+		{spec: "2-ii", want: []int{1}},
+		{spec: "1", byLabel: true, want: []int{4}},
+		{spec: "iii-1", byLabel: true, want: []int{2, 3, 4}},
+		{spec: "foo", wantErr: true},
+		{spec: "foo-3", wantErr: true},
+		{spec: "1-foo", wantErr: true},
+		{spec: "5-2", wantErr: true},
+		{spec: "iv-2", wantErr: true},
+		{spec: "2-i", wantErr: true},
+		{spec: "11", wantErr: true},
+		{spec: "11-", wantErr: true},
+		{spec: "0", wantErr: true},
+		{spec: "7", byLabel: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		pages, err := ParsePageRanges(tt.spec, 10, labels, tt.byLabel)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePageRanges(%q, byLabel=%v) error = %v, wantErr %v", tt.spec, tt.byLabel, err, tt.wantErr)
+			continue
+		}
+
+		if tt.wantErr {
+			continue
+		}
+
+		want := map[int]bool{}
+		for _, i := range tt.want {
+			want[i] = true
+		}
+
+		if !reflect.DeepEqual(pages, want) {
+			t.Errorf("ParsePageRanges(%q, byLabel=%v) = %v, want %v", tt.spec, tt.byLabel, pages, want)
+		}
+	}
+}
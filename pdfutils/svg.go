@@ -0,0 +1,43 @@
+package pdfutils
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+var (
+	svgRootRegex = regexp.MustCompile(`<svg\b[^>]*>`)
+	svgSizeRegex = regexp.MustCompile(`\s(width|height|viewBox)="[^"]*"`)
+)
+
+func formatSVGNumber(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
+
+// ClipSVG sets the size and viewBox of an SVG of a whole page, as returned by
+// fitz, to a rectangle returned by GetPageImageRect. SVG user units are
+// points, so the rectangle is used as is.
+func ClipSVG(svg string, imageRect []float64) (string, error) {
+	x0, x1 := math.Min(imageRect[0], imageRect[2]), math.Max(imageRect[0], imageRect[2])
+	y0, y1 := math.Min(imageRect[1], imageRect[3]), math.Max(imageRect[1], imageRect[3])
+
+	loc := svgRootRegex.FindStringIndex(svg)
+	if loc == nil {
+		return "", fmt.Errorf("Error: page SVG has no root element")
+	}
+
+	root := svgSizeRegex.ReplaceAllString(svg[loc[0]:loc[1]], "")
+	root = root[:len(root)-1] + fmt.Sprintf(
+		` width="%spt" height="%spt" viewBox="%s %s %s %s">`,
+		formatSVGNumber(x1-x0),
+		formatSVGNumber(y1-y0),
+		formatSVGNumber(x0),
+		formatSVGNumber(y0),
+		formatSVGNumber(x1-x0),
+		formatSVGNumber(y1-y0),
+	)
+
+	return svg[:loc[0]] + root + svg[loc[1]:], nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+var readwiseColumns = []string{"Highlight", "Title", "Author", "URL", "Note", "Location", "Date"}
+
+func getReadwiseDate(date string) string {
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return ""
+	}
+
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// Readwise has no colors, so the color category is added to the note as an
+// inline tag, eg. ".yellow"
+func getReadwiseNote(annot *pdfutils.Annotation) string {
+	note := strings.TrimSpace(annot.Comment)
+
+	if annot.ColorCategory != "" {
+		tag := "." + strings.ToLower(annot.ColorCategory)
+		if note == "" {
+			return tag
+		}
+
+		return tag + " " + note
+	}
+
+	return note
+}
+
+// Writes the annotations in the format of Readwise's CSV import. Locations
+// must be numbers, so the physical page is used rather than the page label.
+// Annotations without any text are skipped, since Readwise requires one.
+func writeReadwiseCSV(w *bytes.Buffer, docs []*fileResult) error {
+	out := csv.NewWriter(w)
+
+	if err := out.Write(readwiseColumns); err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		title := getDocumentTitle(doc)
+		author := ""
+		if doc.meta != nil {
+			author = doc.meta.Info["author"]
+		}
+
+		for _, annot := range doc.Annotations {
+			text := annot.AnnotatedText
+			if text == "" {
+				text = annot.OCRText
+			}
+
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+
+			err := out.Write([]string{
+				strings.TrimSpace(text),
+				title,
+				author,
+				getDocumentURL(doc),
+				getReadwiseNote(annot),
+				strconv.Itoa(annot.Page),
+				getReadwiseDate(annot.Date),
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	out.Flush()
+
+	return out.Error()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+)
+
+// Bump when the JSON output changes in a way that could break consumers, eg.
+// a field is removed, renamed, or changes type, and add schema/v<N>.json.
+// Adding an optional field doesn't need a new version, since the schema's
+// objects allow properties it doesn't list.
+const schemaVersion = 1
+
+//go:embed schema/*.json
+var schemaFiles embed.FS
+
+func getSchema(version int) ([]byte, error) {
+	data, err := schemaFiles.ReadFile(fmt.Sprintf("schema/v%d.json", version))
+	if err != nil {
+		return nil, fmt.Errorf("Error: schema version %d is not supported. This version of pdfannots2json outputs schema version %d", version, schemaVersion)
+	}
+
+	return data, nil
+}
+
+// Older schemas are kept so that they can still be printed, but the output
+// only follows the current one
+func validateSchemaVersion() error {
+	if args.SchemaVersion == schemaVersion {
+		return nil
+	}
+
+	if _, err := getSchema(args.SchemaVersion); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("Error: schema version %d is no longer output. This version of pdfannots2json outputs schema version %d", args.SchemaVersion, schemaVersion)
+}
+
+type schemaCmd struct{}
+
+func (c *schemaCmd) Run() error {
+	data, err := getSchema(args.SchemaVersion)
+	if err != nil {
+		return err
+	}
+
+	writeOutput(strings.TrimSpace(string(data)))
+
+	return nil
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/mgmeyers/go-fitz"
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+type serveCmd struct {
+	Listen    string `default:"127.0.0.1:7331" help:"Address to listen on"`
+	Socket    string `type:"path" help:"Listen on this Unix socket instead of a TCP address"`
+	MaxOpen   int    `default:"8" help:"Maximum number of PDFs kept open between requests"`
+	MaxUpload int64  `default:"256" help:"Maximum size of a request body, in MB"`
+	MaxDPI    int    `default:"600" help:"Maximum DPI of pages rendered by /render"`
+}
+
+type documentEntry struct {
+	key     string
+	doc     *pdfDocument
+	refs    int
+	evicted bool
+}
+
+// Keeps recently used PDFs open between requests. Entries are reference
+// counted so that a document evicted while in use is only closed once the
+// last request using it is done.
+type documentLRU struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newDocumentLRU(max int) *documentLRU {
+	if max < 1 {
+		max = 1
+	}
+
+	return &documentLRU{
+		max:     max,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (l *documentLRU) acquire(key string, open func() (*pdfDocument, error)) (*documentEntry, error) {
+	l.mu.Lock()
+	if el, ok := l.entries[key]; ok {
+		entry := el.Value.(*documentEntry)
+		entry.refs++
+		l.order.MoveToFront(el)
+		l.mu.Unlock()
+		return entry, nil
+	}
+	l.mu.Unlock()
+
+	doc, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.entries[key]; ok {
+		doc.Close()
+
+		entry := el.Value.(*documentEntry)
+		entry.refs++
+		l.order.MoveToFront(el)
+		return entry, nil
+	}
+
+	entry := &documentEntry{key: key, doc: doc, refs: 1}
+	l.entries[key] = l.order.PushFront(entry)
+
+	for l.order.Len() > l.max {
+		el := l.order.Back()
+		evicted := el.Value.(*documentEntry)
+
+		l.order.Remove(el)
+		delete(l.entries, evicted.key)
+		evicted.evicted = true
+
+		if evicted.refs == 0 {
+			evicted.doc.Close()
+		}
+	}
+
+	return entry, nil
+}
+
+func (l *documentLRU) release(entry *documentEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.refs--
+
+	if entry.evicted && entry.refs == 0 {
+		entry.doc.Close()
+	}
+}
+
+func (l *documentLRU) closeAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, el := range l.entries {
+		entry := el.Value.(*documentEntry)
+		entry.evicted = true
+
+		if entry.refs == 0 {
+			entry.doc.Close()
+		}
+
+		delete(l.entries, key)
+	}
+
+	l.order.Init()
+}
+
+type server struct {
+	docs    *documentLRU
+	maxBody int64
+	maxDPI  float64
+
+	// Requests may only save images within the --image-output-path the
+	// server was started with
+	imageRoot string
+
+	// Hosts other than these are rejected, so that web pages can't reach the
+	// server through DNS rebinding. Unix sockets can't be reached by browsers,
+	// so they accept any host.
+	listenHost string
+	anyHost    bool
+}
+
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, a ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, err: fmt.Errorf(format, a...)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *server) allowedHost(host string) bool {
+	if s.anyHost || isLoopbackHost(host) {
+		return true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return s.listenHost != "" && host == s.listenHost
+}
+
+// Rejects requests that web pages could make on behalf of the user: those
+// sent to a host name other than the server's, and those from another origin.
+func (s *server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "host not allowed"})
+			return
+		}
+
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "origin not allowed"})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Wraps a handler so that it only accepts POST requests, limits the size of
+// their body, shares the --jobs concurrency limit, and reports errors as JSON.
+func (s *server) handle(fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBody)
+
+		workers.acquire()
+		defer workers.release()
+
+		res, err := fn(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+
+			var hErr *httpError
+			if errors.As(err, &hErr) {
+				status = hErr.status
+			}
+
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+func (s *server) acquirePath(path string) (*documentEntry, error) {
+	if path == "" {
+		return nil, badRequest("Error: path is required")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, &httpError{status: http.StatusNotFound, err: err}
+	}
+
+	// Modified files get a new key, leaving the stale document to be evicted
+	key := fmt.Sprintf("path:%s|%d|%d", path, info.ModTime().UnixNano(), info.Size())
+
+	return s.docs.acquire(key, func() (*pdfDocument, error) {
+		return openPDF(path)
+	})
+}
+
+// Relative paths are resolved against the image root. Paths outside of it are
+// rejected, as is any path when the server has no image root.
+func (s *server) getImageOutputPath(path string) (string, error) {
+	if path == "" {
+		return s.imageRoot, nil
+	}
+
+	if s.imageRoot == "" {
+		return "", badRequest("Error: imageOutputPath requires the server to be started with --image-output-path")
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.imageRoot, path)
+	}
+
+	path = filepath.Clean(path)
+
+	rel, err := filepath.Rel(s.imageRoot, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", badRequest("Error: imageOutputPath must be within %s", s.imageRoot)
+	}
+
+	return path, nil
+}
+
+// Bodies over --max-upload fail to read with an error from http.MaxBytesReader
+func (s *server) checkBodySize(err error) error {
+	if err != nil && strings.Contains(err.Error(), "request body too large") {
+		return &httpError{
+			status: http.StatusRequestEntityTooLarge,
+			err:    fmt.Errorf("Error: request body is larger than %d MB", s.maxBody>>20),
+		}
+	}
+
+	return err
+}
+
+// Reads the first file of a multipart/form-data upload
+func (s *server) readMultipartPDF(r *http.Request) ([]byte, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, badRequest("Error: invalid request: %s", err)
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, badRequest("Error: no PDF in the request")
+		}
+
+		if err != nil {
+			if sizeErr := s.checkBodySize(err); sizeErr != err {
+				return nil, sizeErr
+			}
+
+			return nil, badRequest("Error: invalid request: %s", err)
+		}
+
+		if part.FileName() != "" {
+			data, err := io.ReadAll(part)
+			return data, s.checkBodySize(err)
+		}
+	}
+}
+
+type extractRequest struct {
+	Path            string `json:"path"`
+	ImageOutputPath string `json:"imageOutputPath"`
+}
+
+// Extracts annotations either from the PDF at a path, when sent a JSON body,
+// or from the PDF uploaded as the request body, either on its own or as a
+// multipart/form-data file.
+func (s *server) extract(r *http.Request) (interface{}, error) {
+	req := extractRequest{}
+
+	var entry *documentEntry
+	checksum := ""
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, badRequest("Error: invalid request: %s", err)
+		}
+
+		imageOutputPath, err := s.getImageOutputPath(req.ImageOutputPath)
+		if err != nil {
+			return nil, err
+		}
+		req.ImageOutputPath = imageOutputPath
+
+		e, err := s.acquirePath(req.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		entry = e
+
+		// Checksums are only needed for the cache and the envelope, as in the CLI
+		if args.CacheDir != "" || args.Envelope {
+			checksum, err = getFileChecksum(req.Path)
+			if err != nil {
+				s.docs.release(entry)
+				return nil, err
+			}
+		}
+	case "application/pdf", "multipart/form-data":
+		imageOutputPath, err := s.getImageOutputPath(r.URL.Query().Get("imageOutputPath"))
+		if err != nil {
+			return nil, err
+		}
+		req.ImageOutputPath = imageOutputPath
+
+		var data []byte
+		if mediaType == "application/pdf" {
+			data, err = io.ReadAll(r.Body)
+			err = s.checkBodySize(err)
+		} else {
+			data, err = s.readMultipartPDF(r)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(data)
+		checksum = hex.EncodeToString(sum[:])
+
+		e, err := s.docs.acquire("sha256:"+checksum, func() (*pdfDocument, error) {
+			return openPDFBytes(data)
+		})
+		if err != nil {
+			return nil, badRequest("%s", err)
+		}
+
+		entry = e
+	default:
+		return nil, &httpError{
+			status: http.StatusUnsupportedMediaType,
+			err:    fmt.Errorf("Error: send a path as application/json, or a PDF as application/pdf or multipart/form-data"),
+		}
+	}
+	defer s.docs.release(entry)
+
+	cache, err := newExtractionCache(shouldSkipImages(req.ImageOutputPath))
+	if err != nil {
+		return nil, err
+	}
+
+	annots, meta, err := extractDocumentAnnotations(entry.doc, checksum, req.ImageOutputPath, cache)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applyDocumentMetadata(annots, meta); err != nil {
+		return nil, err
+	}
+
+	if !args.Envelope {
+		return annots, nil
+	}
+
+	meta.Path = req.Path
+	meta.SHA256 = checksum
+
+	return envelope{
+		SchemaVersion: schemaVersion,
+		Tool:          getToolMetadata(req.ImageOutputPath),
+		Document:      meta,
+		Annotations:   annots,
+	}, nil
+}
+
+func renderPage(doc *pdfDocument, pageNum int, dpi float64) (image.Image, error) {
+	if pageNum < 1 {
+		return nil, badRequest("Error: page %d does not exist", pageNum)
+	}
+
+	// fitz isn't safe for concurrent use, and not all of its calls lock
+	doc.mu.Lock()
+	defer doc.mu.Unlock()
+
+	img, err := doc.fitzDoc.ImageDPI(pageNum-1, dpi)
+	if err == fitz.ErrPageMissing {
+		return nil, badRequest("Error: page %d does not exist", pageNum)
+	}
+
+	return img, err
+}
+
+type renderRequest struct {
+	Path    string  `json:"path"`
+	Page    int     `json:"page"`
+	DPI     float64 `json:"dpi"`
+	Format  string  `json:"format"`
+	Quality int     `json:"quality"`
+}
+
+type renderResponse struct {
+	Page   int    `json:"page"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Data   string `json:"data"`
+}
+
+func (s *server) render(r *http.Request) (interface{}, error) {
+	req := renderRequest{
+		Page:    1,
+		DPI:     float64(args.ImageDPI),
+		Format:  args.ImageFormat,
+		Quality: args.ImageQuality,
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, badRequest("Error: invalid request: %s", err)
+	}
+
+	if req.Format != "jpg" && req.Format != "png" {
+		return nil, badRequest("Error: unsupported format %s", req.Format)
+	}
+
+	// A page rendered at a high enough DPI would exhaust the server's memory
+	if req.DPI <= 0 || req.DPI > s.maxDPI {
+		return nil, badRequest("Error: dpi must be greater than 0 and at most %g", s.maxDPI)
+	}
+
+	entry, err := s.acquirePath(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer s.docs.release(entry)
+
+	img, err := renderPage(entry.doc, req.Page, req.DPI)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	if err := pdfutils.EncodeImage(&buf, &img, req.Format, req.Quality); err != nil {
+		return nil, err
+	}
+
+	return &renderResponse{
+		Page:   req.Page,
+		Format: req.Format,
+		Width:  img.Bounds().Dx(),
+		Height: img.Bounds().Dy(),
+		Data:   base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+type ocrRequest struct {
+	Path string    `json:"path"`
+	Page int       `json:"page"`
+	Rect []float64 `json:"rect"`
+	Lang string    `json:"lang"`
+}
+
+// OCRs a page, or the part of it within rect, given in PDF coordinates.
+func (s *server) ocr(r *http.Request) (interface{}, error) {
+	req := ocrRequest{Page: 1, Lang: args.OCRLang}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, badRequest("Error: invalid request: %s", err)
+	}
+
+	if req.Rect != nil && len(req.Rect) != 4 {
+		return nil, badRequest("Error: rect must contain 4 numbers")
+	}
+
+	if !pdfutils.CheckForTesseract(args.TesseractPath) {
+		return nil, fmt.Errorf("Error: %s not found", args.TesseractPath)
+	}
+
+	if !pdfutils.ValidateLang(args.TesseractPath, req.Lang) {
+		return nil, badRequest("Error: %s not a valid tesseract language string", req.Lang)
+	}
+
+	entry, err := s.acquirePath(req.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer s.docs.release(entry)
+
+	img, err := renderPage(entry.doc, req.Page, 300.0)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Rect != nil {
+		entry.doc.mu.Lock()
+		page, err := entry.doc.getPage(req.Page - 1)
+		entry.doc.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		if page == nil {
+			return nil, fmt.Errorf("Error: page %d has no media box", req.Page)
+		}
+
+		rect := []float64{
+			math.Min(req.Rect[0], req.Rect[2]),
+			math.Min(req.Rect[1], req.Rect[3]),
+			math.Max(req.Rect[0], req.Rect[2]),
+			math.Max(req.Rect[1], req.Rect[3]),
+		}
+
+		imageRect, width := pdfutils.GetPageImageRect(page, rect)
+
+		cropped, err := pdfutils.CropPageImage(&img, imageRect, width)
+		if err != nil {
+			return nil, err
+		}
+
+		img = cropped
+	}
+
+	if img.Bounds().Empty() {
+		return nil, badRequest("Error: rect is outside of the page")
+	}
+
+	text, err := pdfutils.OCRImage(img, args.TesseractPath, req.Lang, args.TessDataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"text": text}, nil
+}
+
+func (c *serveCmd) Run() error {
+	if err := validateSchemaVersion(); err != nil {
+		return err
+	}
+
+	if _, err := loadBibliography(); err != nil {
+		return err
+	}
+
+	if err := validateInlineImages(); err != nil {
+		return err
+	}
+
+	s := &server{
+		docs:      newDocumentLRU(c.MaxOpen),
+		maxBody:   c.MaxUpload << 20,
+		maxDPI:    float64(c.MaxDPI),
+		imageRoot: args.ImageOutputPath,
+		anyHost:   c.Socket != "",
+	}
+
+	if host, _, err := net.SplitHostPort(c.Listen); err == nil {
+		s.listenHost = host
+	}
+	defer s.docs.closeAll()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/extract", s.handle(s.extract))
+	mux.HandleFunc("/render", s.handle(s.render))
+	mux.HandleFunc("/ocr", s.handle(s.ocr))
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"version": version})
+	})
+
+	var listener net.Listener
+	var err error
+
+	if c.Socket != "" {
+		// A socket left behind by a previous run is replaced, but anything
+		// else at the path is left alone
+		if info, err := os.Lstat(c.Socket); err == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				return fmt.Errorf("Error: %s exists and is not a socket", c.Socket)
+			}
+
+			if err := os.Remove(c.Socket); err != nil {
+				return err
+			}
+		}
+
+		listener, err = net.Listen("unix", c.Socket)
+	} else {
+		listener, err = net.Listen("tcp", c.Listen)
+	}
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: s.guard(mux)}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	eLog := log.New(os.Stderr, "", 0)
+	eLog.Printf("Listening on %s", listener.Addr())
+
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
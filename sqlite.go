@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+// Stored as the database's user_version
+const sqliteSchemaVersion = 1
+
+// Documents are keyed on their checksum, and annotations on their document
+// and their name (NM), or their ID when they have none, so re-exporting a
+// document after editing it updates its rows in place. Both have an integer
+// key for joins and the full text index, which is kept in sync with the
+// annotations table by triggers.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS documents (
+	id INTEGER PRIMARY KEY,
+	hash TEXT NOT NULL UNIQUE,
+	path TEXT,
+	title TEXT,
+	page_count INTEGER,
+	pdf_version TEXT,
+	citekey TEXT,
+	doi TEXT,
+	arxiv TEXT,
+	isbn TEXT,
+	metadata TEXT,
+	updated_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS documents_path ON documents (path);
+
+CREATE TABLE IF NOT EXISTS pages (
+	document_id INTEGER NOT NULL REFERENCES documents (id) ON DELETE CASCADE,
+	page INTEGER NOT NULL,
+	label TEXT NOT NULL,
+	PRIMARY KEY (document_id, page)
+);
+
+CREATE TABLE IF NOT EXISTS annotations (
+	id INTEGER PRIMARY KEY,
+	document_id INTEGER NOT NULL REFERENCES documents (id) ON DELETE CASCADE,
+	key TEXT NOT NULL,
+	annotation_id TEXT NOT NULL,
+	name TEXT,
+	type TEXT NOT NULL,
+	page INTEGER NOT NULL,
+	page_label TEXT,
+	section TEXT,
+	author TEXT,
+	color TEXT,
+	color_category TEXT,
+	date TEXT,
+	annotated_text TEXT,
+	comment TEXT,
+	ocr_text TEXT,
+	image_path TEXT,
+	citekey TEXT,
+	x REAL,
+	y REAL,
+	rect TEXT,
+	data TEXT NOT NULL,
+	UNIQUE (document_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS annotations_page ON annotations (document_id, page);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS annotations_fts USING fts5 (
+	annotated_text,
+	comment,
+	ocr_text,
+	content = 'annotations',
+	content_rowid = 'id'
+);
+
+CREATE TRIGGER IF NOT EXISTS annotations_ai AFTER INSERT ON annotations BEGIN
+	INSERT INTO annotations_fts (rowid, annotated_text, comment, ocr_text)
+	VALUES (new.id, new.annotated_text, new.comment, new.ocr_text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS annotations_ad AFTER DELETE ON annotations BEGIN
+	INSERT INTO annotations_fts (annotations_fts, rowid, annotated_text, comment, ocr_text)
+	VALUES ('delete', old.id, old.annotated_text, old.comment, old.ocr_text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS annotations_au AFTER UPDATE ON annotations BEGIN
+	INSERT INTO annotations_fts (annotations_fts, rowid, annotated_text, comment, ocr_text)
+	VALUES ('delete', old.id, old.annotated_text, old.comment, old.ocr_text);
+	INSERT INTO annotations_fts (rowid, annotated_text, comment, ocr_text)
+	VALUES (new.id, new.annotated_text, new.comment, new.ocr_text);
+END;
+`
+
+func checkForSQLite(path string) bool {
+	_, err := exec.LookPath(path)
+	return err == nil
+}
+
+// Empty strings are stored as NULL
+func sqlString(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func sqlJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return "NULL"
+	}
+
+	return sqlString(string(data))
+}
+
+func sqlFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Annotations are keyed on their name, which PDF editors keep when an
+// annotation is edited, unless another annotation of the document has the
+// same name
+func getSQLiteAnnotationKeys(annots []*pdfutils.Annotation) []string {
+	names := map[string]int{}
+	for _, a := range annots {
+		if a.Name != "" {
+			names[a.Name]++
+		}
+	}
+
+	keys := make([]string, len(annots))
+	for i, a := range annots {
+		if a.Name != "" && names[a.Name] == 1 {
+			keys[i] = "name:" + a.Name
+		} else {
+			keys[i] = "id:" + a.ID
+		}
+	}
+
+	return keys
+}
+
+func writeSQLiteDocument(w *bytes.Buffer, doc *fileResult, now string) {
+	hash := sqlString(doc.Checksum)
+	docID := fmt.Sprintf("(SELECT id FROM documents WHERE hash = %s)", hash)
+
+	path := "NULL"
+	if doc.Path != stdinPath {
+		path = sqlString(doc.Path)
+	}
+
+	meta := doc.meta
+	if meta == nil {
+		meta = &documentMetadata{}
+	}
+
+	ids := meta.Identifiers
+	if ids == nil {
+		ids = &pdfutils.Identifiers{}
+	}
+
+	// A PDF that was edited in place keeps its row, and with it the IDs of
+	// its annotations, unless its new checksum is already in the database
+	if path != "NULL" {
+		fmt.Fprintf(w, "UPDATE documents SET hash = %s WHERE path = %s AND hash <> %s AND NOT EXISTS (SELECT 1 FROM documents WHERE hash = %s);\n",
+			hash, path, hash, hash)
+	}
+
+	fmt.Fprintf(w, `INSERT INTO documents (hash, path, title, page_count, pdf_version, citekey, doi, arxiv, isbn, metadata, updated_at)
+VALUES (%s, %s, %s, %d, %s, %s, %s, %s, %s, %s, %s)
+ON CONFLICT (hash) DO UPDATE SET path = coalesce(excluded.path, path), title = excluded.title, page_count = excluded.page_count,
+pdf_version = excluded.pdf_version, citekey = excluded.citekey, doi = excluded.doi, arxiv = excluded.arxiv,
+isbn = excluded.isbn, metadata = excluded.metadata, updated_at = excluded.updated_at;
+`,
+		hash, path, sqlString(getDocumentTitle(doc)), meta.PageCount, sqlString(meta.PdfVersion),
+		sqlString(meta.Citekey), sqlString(ids.DOI), sqlString(ids.ArXiv), sqlString(ids.ISBN), sqlJSON(meta), sqlString(now),
+	)
+
+	fmt.Fprintf(w, "DELETE FROM pages WHERE document_id = %s AND page > %d;\n", docID, meta.PageCount)
+
+	for i := 0; i < meta.PageCount; i++ {
+		label := strconv.Itoa(i + 1)
+		if i < len(meta.PageLabels) {
+			label = meta.PageLabels[i]
+		}
+
+		fmt.Fprintf(w, "INSERT INTO pages (document_id, page, label) VALUES (%s, %d, %s) ON CONFLICT (document_id, page) DO UPDATE SET label = excluded.label;\n",
+			docID, i+1, sqlString(label))
+	}
+
+	keys := getSQLiteAnnotationKeys(doc.Annotations)
+
+	// Annotations that were deleted from the PDF are removed
+	if len(keys) == 0 {
+		fmt.Fprintf(w, "DELETE FROM annotations WHERE document_id = %s;\n", docID)
+	} else {
+		quoted := make([]string, len(keys))
+		for i, key := range keys {
+			quoted[i] = sqlString(key)
+		}
+
+		fmt.Fprintf(w, "DELETE FROM annotations WHERE document_id = %s AND key NOT IN (%s);\n", docID, strings.Join(quoted, ", "))
+	}
+
+	for i, a := range doc.Annotations {
+		fmt.Fprintf(w, `INSERT INTO annotations (document_id, key, annotation_id, name, type, page, page_label, section, author, color,
+color_category, date, annotated_text, comment, ocr_text, image_path, citekey, x, y, rect, data)
+VALUES (%s, %s, %s, %s, %s, %d, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s, %s)
+ON CONFLICT (document_id, key) DO UPDATE SET annotation_id = excluded.annotation_id, name = excluded.name, type = excluded.type,
+page = excluded.page, page_label = excluded.page_label, section = excluded.section, author = excluded.author,
+color = excluded.color, color_category = excluded.color_category, date = excluded.date,
+annotated_text = excluded.annotated_text, comment = excluded.comment, ocr_text = excluded.ocr_text,
+image_path = excluded.image_path, citekey = excluded.citekey, x = excluded.x, y = excluded.y, rect = excluded.rect,
+data = excluded.data;
+`,
+			docID, sqlString(keys[i]), sqlString(a.ID), sqlString(a.Name), sqlString(a.Type), a.Page, sqlString(a.PageLabel),
+			sqlJSON(a.Section), sqlString(a.Author), sqlString(a.Color), sqlString(a.ColorCategory), sqlString(a.Date),
+			sqlString(a.AnnotatedText), sqlString(a.Comment), sqlString(a.OCRText), sqlString(a.ImagePath), sqlString(a.Citekey),
+			sqlFloat(a.X), sqlFloat(a.Y), sqlJSON(a.Rect), sqlJSON(a),
+		)
+	}
+}
+
+// Upserts the documents into the database in a single transaction, through
+// the sqlite3 command line shell. The shell must be built with FTS5, as it is
+// by default.
+func writeSQLite(docs []*fileResult) error {
+	script := &bytes.Buffer{}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	script.WriteString("PRAGMA foreign_keys = ON;\nBEGIN;\n")
+	script.WriteString(sqliteSchema)
+	fmt.Fprintf(script, "PRAGMA user_version = %d;\n", sqliteSchemaVersion)
+
+	for _, doc := range docs {
+		writeSQLiteDocument(script, doc, now)
+	}
+
+	script.WriteString("COMMIT;\n")
+
+	cmd := exec.Command(args.SQLitePath, "-bail", args.DB)
+	cmd.Stdin = script
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Error: writing to %s failed: %s", args.DB, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
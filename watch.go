@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mgmeyers/pdfannots2json/pdfutils"
+)
+
+const (
+	watchAdded   = "added"
+	watchUpdated = "updated"
+	watchRemoved = "removed"
+	watchError   = "error"
+)
+
+type watchEvent struct {
+	Event      string               `json:"event"`
+	Path       string               `json:"path"`
+	Annotation *pdfutils.Annotation `json:"annotation,omitempty"`
+	Changes    []string             `json:"changes,omitempty"`
+	Error      string               `json:"error,omitempty"`
+}
+
+type watchedFile struct {
+	size        int64
+	modTime     time.Time
+	changedAt   time.Time
+	pending     bool
+	missing     bool
+	annotations []*pdfutils.Annotation
+}
+
+func emitDiff(path string, diff *pdfutils.AnnotationDiff) {
+	for _, annot := range diff.Removed {
+		logOutput(watchEvent{Event: watchRemoved, Path: path, Annotation: annot})
+	}
+
+	for _, modified := range diff.Modified {
+		logOutput(watchEvent{
+			Event:      watchUpdated,
+			Path:       path,
+			Annotation: modified.New,
+			Changes:    modified.Changes,
+		})
+	}
+
+	for _, annot := range diff.Added {
+		logOutput(watchEvent{Event: watchAdded, Path: path, Annotation: annot})
+	}
+}
+
+func extractWatched(path string, batch bool) ([]*pdfutils.Annotation, error) {
+	workers.acquire()
+	defer workers.release()
+
+	if batch {
+		result := processFile(path)
+		if len(result.Errors) > 0 {
+			return nil, errors.New(strings.Join(result.Errors, "; "))
+		}
+
+		return result.Annotations, nil
+	}
+
+	checksum := ""
+
+	if args.CacheDir != "" {
+		var err error
+		if checksum, err = getFileChecksum(path); err != nil {
+			return nil, err
+		}
+	}
+
+	annots, _, err := extractAnnotations(path, checksum, args.ImageOutputPath)
+
+	return annots, err
+}
+
+// Inputs are expanded one at a time, so that a missing input, eg. a file an
+// editor deletes before writing it again, only leaves out its own files. Once
+// watching has started, a missing input is treated as empty rather than as an
+// error.
+func expandWatchedInputs(inputs []string, started bool) ([]string, bool, error) {
+	paths := []string{}
+	seen := map[string]bool{}
+	batch := len(inputs) > 1
+
+	for _, input := range inputs {
+		expanded, b, err := expandInputs([]string{input}, args.Recursive)
+		if err != nil {
+			if !started {
+				return nil, false, err
+			}
+
+			if _, statErr := os.Stat(input); os.IsNotExist(statErr) {
+				continue
+			}
+
+			return nil, false, err
+		}
+
+		batch = batch || b
+
+		for _, path := range expanded {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	return paths, batch, nil
+}
+
+// Polls the inputs for changes. A new or modified file is only extracted once
+// its size and modification time have stopped changing for the settle
+// duration, so that PDFs are not read while they are still being written. A
+// file that disappears is kept for one more interval before its annotations
+// are reported as removed, so that a file an editor deletes and writes again
+// is reported as updated.
+func watchInputs(inputs []string) error {
+	for _, input := range inputs {
+		if input == stdinPath {
+			return fmt.Errorf("Error: stdin can't be watched")
+		}
+	}
+
+	files := map[string]*watchedFile{}
+
+	update := func(path string, batch bool, file *watchedFile) {
+		annots, err := extractWatched(path, batch)
+		if err != nil {
+			logOutput(watchEvent{Event: watchError, Path: path, Error: err.Error()})
+			return
+		}
+
+		emitDiff(path, pdfutils.DiffAnnotations(file.annotations, annots))
+		file.annotations = annots
+	}
+
+	for started := false; ; started = true {
+		paths, batch, err := expandWatchedInputs(inputs, started)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		seen := map[string]bool{}
+
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			seen[path] = true
+
+			file, ok := files[path]
+			if !ok {
+				file = &watchedFile{
+					size:        info.Size(),
+					modTime:     info.ModTime(),
+					annotations: []*pdfutils.Annotation{},
+				}
+				files[path] = file
+
+				// Files that exist when watching starts are extracted right
+				// away, later ones may still be being written
+				if !started {
+					update(path, batch, file)
+				} else {
+					file.changedAt = now
+					file.pending = true
+				}
+				continue
+			}
+
+			if file.missing || info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+				file.size = info.Size()
+				file.modTime = info.ModTime()
+				file.changedAt = now
+				file.pending = true
+				file.missing = false
+				continue
+			}
+
+			if file.pending && now.Sub(file.changedAt) >= args.WatchSettle {
+				file.pending = false
+				update(path, batch, file)
+			}
+		}
+
+		for path, file := range files {
+			if seen[path] {
+				continue
+			}
+
+			if !file.missing {
+				file.missing = true
+				file.pending = false
+				continue
+			}
+
+			emitDiff(path, pdfutils.DiffAnnotations(file.annotations, []*pdfutils.Annotation{}))
+			delete(files, path)
+		}
+
+		time.Sleep(args.WatchInterval)
+	}
+}